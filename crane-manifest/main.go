@@ -2,14 +2,24 @@
 package main
 
 import (
+	"bytes"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/RedCoolBeans/crane/util/gpg"
+	"github.com/RedCoolBeans/crane/util/hash"
 	"github.com/RedCoolBeans/crane/util/logging"
 	"github.com/RedCoolBeans/crane/util/manifest"
+	"github.com/RedCoolBeans/crane/util/sshsig"
 	"github.com/davecgh/go-spew/spew"
+	"gopkg.in/yaml.v2"
 )
 
 func main() {
@@ -17,22 +27,74 @@ func main() {
 	debug := flag.Bool("debug", false, "Enable debug output")
 
 	strict := flag.Bool("strict", false, "Enable signature checking")
-	pubkey := flag.String("pubkey", "pubkey.asc", "Path to GPG public key")
+	pubkey := flag.String("pubkey", "pubkey.asc", "Path to GPG public key or directory of keys")
 	signature := flag.String("sig", "MANIFEST.yaml.sig", "Path to Manifest signature")
+	threshold := flag.Int("sig-threshold", 1, "Number of distinct trusted keys required to have signed the manifest")
+	allowedSigners := flag.String("allowed-signers", "", "Path to allowed_signers file, verifies SSH signatures instead of GPG")
+	sshNamespace := flag.String("ssh-namespace", "crane", "Namespace SSH signatures have to be made in")
+	seckey := flag.String("seckey", "seckey.asc", "Path to GPG secret key to sign with")
+	passfile := flag.String("passphrase-file", "", "Path to file with the passphrase for -seckey (default: $CRANE_PASSPHRASE)")
+	prefix := flag.String("prefix", "", "Prefix into the repository to the files, for the tree hash and checksums")
+	algos := flag.String("hash", hash.DEFAULT_ALGO, "Comma separated list of hashing algorithms to emit checksums for")
 
 	flag.Parse()
+
+	if *threshold < 1 {
+		logging.PrError("Invalid -sig-threshold=%d, at least one signature is required", *threshold)
+	}
+
+	// The signature lives next to the manifest, unless -sig says otherwise.
+	explicitSig := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "sig" {
+			explicitSig = true
+		}
+	})
+	if !explicitSig {
+		*signature = path.Join(path.Dir(*file), *signature)
+	}
+
 	m := manifest.ReadFile(*file)
 
 	if *debug {
 		spew.Dump(m)
 	}
 
-	if *strict {
-		if ok, ids := gpg.Verify(*pubkey, *signature, "", *debug); ok {
+	switch flag.Arg(0) {
+	case "sign":
+		sign(*file, m, *seckey, *passfile, *signature, *prefix)
+		return
+	case "checksums":
+		checksums(*file, m, *prefix, strings.Split(*algos, ","))
+		return
+	case "tree":
+		tree, err := treeHash(*file, m, *prefix)
+		if err != nil {
+			logging.PrError("Could not compute tree hash: %s", err)
+		}
+		fmt.Printf("tree_sha256: %s\n", tree)
+		return
+	}
+
+	if *strict && *allowedSigners != "" {
+		if signers := sshsig.VerifyAll(*allowedSigners, *sshNamespace, *signature, "", *debug); len(signers) >= *threshold {
 			logging.PrInfoBegin("Signature for MANIFEST.yaml verified\n")
-			logging.PrInfoEnd("Signed by: %s\n", strings.Join(ids, "\n\t"))
+			for _, signer := range signers {
+				logging.PrInfoEnd("Signed by: %s\n\tKey fingerprint: %s", strings.Join(signer.Principals, "\n\t"), signer.Fingerprint)
+			}
 		} else {
-			logging.PrError("INVALID signature for MANIFEST.yaml! Aborting.")
+			logging.PrError("Only %d of %d required signatures for MANIFEST.yaml are valid! Aborting.",
+				len(signers), *threshold)
+		}
+	} else if *strict {
+		if signers := gpg.VerifyAll(*pubkey, *signature, "", *debug); len(signers) >= *threshold {
+			logging.PrInfoBegin("Signature for MANIFEST.yaml verified\n")
+			for _, signer := range signers {
+				logging.PrInfoEnd("Signed by: %s\n\tKey fingerprint: %s", strings.Join(signer.Identities, "\n\t"), signer.Fingerprint)
+			}
+		} else {
+			logging.PrError("Only %d of %d required signatures for MANIFEST.yaml are valid! Aborting.",
+				len(signers), *threshold)
 		}
 	}
 
@@ -40,3 +102,157 @@ func main() {
 		log.Fatalln(err)
 	}
 }
+
+// sign creates a detached signature for the manifest, but only if it's valid
+// and the checksums in it are up-to-date.
+func sign(file string, m map[interface{}]interface{}, seckey string, passfile string, signature string, prefix string) {
+	if err := manifest.Validate(m); err != nil {
+		log.Fatalln(err)
+	}
+
+	if stale := hash.Stale(manifest.Contents(m), path.Dir(file)); len(stale) > 0 {
+		logging.PrError("Refusing to sign, checksums are stale or files missing for:\n\t%s",
+			strings.Join(stale, "\n\t"))
+	}
+
+	if expected := manifest.TreeSha256(m); expected != "" {
+		if tree, err := treeHash(file, m, prefix); err != nil {
+			logging.PrError("Could not compute tree hash: %s", err)
+		} else if tree != expected {
+			logging.PrError("Refusing to sign, tree_sha256 is stale, it should be:\n\ttree_sha256: %s", tree)
+		}
+	}
+
+	passphrase := []byte(os.Getenv("CRANE_PASSPHRASE"))
+	if passfile != "" {
+		data, err := ioutil.ReadFile(passfile)
+		if err != nil {
+			logging.PrError("Could not read passphrase: %v", err)
+		}
+		passphrase = bytes.TrimRight(data, "\r\n")
+	}
+
+	signer, err := gpg.ReadSecretKey(seckey, passphrase)
+	if err != nil {
+		logging.PrError("%s", err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		logging.PrError("Could not read %s: %v", file, err)
+	}
+
+	var sig bytes.Buffer
+	if err := gpg.Sign(signer, data, &sig); err != nil {
+		logging.PrError("Could not sign %s: %v", file, err)
+	}
+
+	if err := ioutil.WriteFile(signature, sig.Bytes(), 0644); err != nil {
+		logging.PrError("Could not write %s: %v", signature, err)
+	}
+
+	logging.PrInfo("Signed %s with key %s into %s", file, gpg.Fingerprint(signer.PrimaryKey), signature)
+}
+
+// treeHash computes the tree hash over the package the manifest `file` is part of.
+func treeHash(file string, m map[interface{}]interface{}, prefix string) (string, error) {
+	dir, err := filepath.Abs(path.Dir(file))
+	if err != nil {
+		return "", err
+	}
+
+	return hash.TreeSha256(path.Join(dir, prefix), dir, manifest.IgnorePatterns(m))
+}
+
+// checksums prints a `contents` section with checksums using `algos` for every
+// file that would be installed. Modes, as well as entries which aren't files
+// (i.e. directories), are carried over from the current manifest.
+func checksums(file string, m map[interface{}]interface{}, prefix string, algos []string) {
+	for _, algo := range algos {
+		if _, err := hash.New(algo); err != nil {
+			logging.PrError("%s", err)
+		}
+	}
+
+	dir, err := filepath.Abs(path.Dir(file))
+	if err != nil {
+		logging.PrError("%s", err)
+	}
+
+	files, err := hash.Files(path.Join(dir, prefix), dir, manifest.IgnorePatterns(m))
+	if err != nil {
+		logging.PrError("Could not list files: %s", err)
+	}
+
+	existing := manifest.Contents(m)
+
+	fmt.Println("contents:")
+	for _, src := range files {
+		printEntry(src, findEntry(existing, src), algos)
+
+		for _, algo := range algos {
+			sum, err := hash.File(path.Join(dir, src), algo)
+			if err != nil {
+				logging.PrError("Could not calculate hash for %s: %s", src, err)
+			}
+			fmt.Printf("    %s: %x\n", algo, sum)
+		}
+	}
+
+	for _, c := range existing {
+		entry := c.(map[interface{}]interface{})
+		if src := entry["path"].(string); !contains(files, src) {
+			printEntry(src, entry, nil)
+		}
+	}
+}
+
+// printEntry prints the `contents` entry for `src`, carrying over all fields of
+// the existing `entry` except for the checksums in `algos`, which are printed
+// by the caller.
+func printEntry(src string, entry map[interface{}]interface{}, algos []string) {
+	fmt.Printf("  - path: %s\n", src)
+	if mode, ok := entry["mode"].(int); ok {
+		fmt.Printf("    mode: %#o\n", mode)
+	}
+
+	keys := make([]string, 0)
+	for k := range entry {
+		if key := fmt.Sprint(k); key != "path" && key != "mode" && !contains(algos, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		data, err := yaml.Marshal(map[string]interface{}{key: entry[key]})
+		if err != nil {
+			logging.PrError("Could not copy %s of %s: %s", key, src, err)
+		}
+
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+}
+
+func findEntry(contents []interface{}, file string) map[interface{}]interface{} {
+	for _, c := range contents {
+		entry := c.(map[interface{}]interface{})
+		if entry["path"].(string) == file {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
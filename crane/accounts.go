@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/RedCoolBeans/crane/util"
+	log "github.com/RedCoolBeans/crane/util/logging"
+	m "github.com/RedCoolBeans/crane/util/manifest"
+	"github.com/RedCoolBeans/crane/util/users"
+)
+
+// accountsFor returns the users and groups of `destination`, which are only
+// read once so packages see the accounts created by the packages before them.
+func accountsFor(destination string) *users.Database {
+	if accounts, ok := userDatabases[destination]; ok {
+		return accounts
+	}
+
+	accounts, err := users.Load(destination)
+	if err != nil {
+		log.PrError(err.Error())
+	}
+	userDatabases[destination] = accounts
+
+	return accounts
+}
+
+// createAccounts adds the groups and users declared in the manifest to the
+// accounts of -destination, regardless of the package's own destination.
+// Nothing is written until the installation is committed, see writeAccounts.
+func createAccounts(manifest map[interface{}]interface{}) {
+	if err := m.CreateAccounts(manifest, accountsFor(*root)); err != nil {
+		log.PrError(err.Error())
+	}
+}
+
+// writeAccounts stages etc/passwd, etc/group and etc/shadow of every
+// destination where users or groups were added, so they're committed along
+// with the packages.
+func writeAccounts() {
+	for destination, accounts := range userDatabases {
+		if !accounts.Changed() {
+			continue
+		}
+
+		staging, err := tx.Stage(destination)
+		util.Check(err, false)
+
+		err = accounts.Write(staging)
+		util.Check(err, false)
+	}
+}
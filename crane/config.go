@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/RedCoolBeans/crane/util"
+	"github.com/RedCoolBeans/crane/util/db"
+	log "github.com/RedCoolBeans/crane/util/logging"
+)
+
+// planConfigFiles decides what happens to every configuration file of the
+// package about to be installed, see db.PlanConfigFiles. Modified
+// configuration files are left untouched, and the new version is staged as
+// PATH.crane-new instead.
+func planConfigFiles(receipt *db.Receipt) {
+	previous, _, err := db.ReadReceipt(*dbdir, receipt.Name)
+	util.Check(err, false)
+
+	plan, err := db.PlanConfigFiles(*root, previous, *receipt)
+	if err != nil {
+		log.PrError(err.Error())
+	}
+
+	staging, err := tx.Stage(destinationOf(*receipt))
+	util.Check(err, false)
+
+	added := make([]db.File, 0)
+	for _, file := range receipt.Files {
+		if !file.Config {
+			continue
+		}
+		target := db.Target(*root, *receipt, file)
+
+		switch plan[file.Path] {
+		case db.CONFIG_INSTALL:
+			log.PrInfo("Installing configuration file %s", target)
+		case db.CONFIG_UNCHANGED:
+			log.PrVerbose(*verbose, "Configuration file %s is unchanged", target)
+		case db.CONFIG_UPDATE:
+			log.PrInfo("Updating configuration file %s", target)
+		case db.CONFIG_KEEP:
+			staged := path.Join(staging, file.Path)
+			if err := os.Rename(staged, staged+db.CONFIG_NEW_SUFFIX); err != nil {
+				log.PrError("Could not stage %s: %s", staged+db.CONFIG_NEW_SUFFIX, err)
+			}
+
+			log.PrInfo("Keeping modified configuration file %s, installing the new version as %s",
+				target, target+db.CONFIG_NEW_SUFFIX)
+
+			// The package still owns the configuration file, as well as the new version.
+			added = append(added, db.NewConfigFile(file))
+		}
+	}
+
+	receipt.Files = append(receipt.Files, added...)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/RedCoolBeans/crane/util"
+	"github.com/RedCoolBeans/crane/util/db"
+	"github.com/RedCoolBeans/crane/util/fs"
+	"github.com/RedCoolBeans/crane/util/hash"
+	log "github.com/RedCoolBeans/crane/util/logging"
+	m "github.com/RedCoolBeans/crane/util/manifest"
+)
+
+// checkConflicts makes sure no path is about to be owned by two packages, and
+// that files which aren't managed by Crane are only overwritten as allowed by
+// the overwrite policy. A package may take ownership of another package's
+// files if it `replaces` it. Returns the paths taken over from installed
+// packages, keyed by package name.
+func checkConflicts() map[string][]string {
+	receipts, err := db.Receipts(*dbdir)
+	util.Check(err, false)
+
+	claims := db.ClaimPaths(*root, receipts, pending)
+	for _, conflict := range claims.Conflicts {
+		log.PrInfo("Conflict: %s", conflict)
+	}
+	conflicts := len(claims.Conflicts)
+
+	// Paths owned by a package are subject to the claims, not to the overwrite policy.
+	owned := make(map[string]bool)
+	for _, r := range receipts {
+		for _, file := range r.Files {
+			if file.Type != db.TYPE_DIR {
+				owned[db.Target(*root, r, file)] = true
+			}
+		}
+	}
+
+	for _, r := range pending {
+		skipped := make([]string, 0)
+
+		for i, file := range r.Files {
+			if file.Type == db.TYPE_DIR {
+				continue
+			}
+			target := db.Target(*root, *r, file)
+
+			// Existing configuration files are never overwritten, see planConfigFiles.
+			if owned[target] || file.Config {
+				continue
+			}
+			if _, err := os.Lstat(target); err != nil {
+				continue
+			}
+
+			switch overwritePolicy(target) {
+			case m.OVERWRITE_OVERWRITE:
+				log.PrVerbose(*verbose, "Overwriting %s", target)
+				continue
+			case m.OVERWRITE_BACKUP:
+				if _, err := os.Lstat(target + fs.ORIG_SUFFIX); err == nil {
+					log.PrInfo("Conflict: %s of %s can't be backed up, %s already exists", target, r.Name, target+fs.ORIG_SUFFIX)
+					conflicts++
+					continue
+				}
+				log.PrInfo("Keeping original %s as %s", target, target+fs.ORIG_SUFFIX)
+				tx.Preserve(target)
+				r.Files[i].Orig = file.Path + fs.ORIG_SUFFIX
+				continue
+			case m.OVERWRITE_SKIP_IF_IDENTICAL:
+				if identical(target, file) {
+					log.PrVerbose(*verbose, "Skipping %s, it's identical", target)
+					skipped = append(skipped, target)
+					continue
+				}
+			}
+
+			log.PrInfo("Conflict: %s of %s would overwrite a file not installed by Crane", target, r.Name)
+			conflicts++
+		}
+
+		// Files which are skipped aren't installed, and thus not owned by the package.
+		destination := destinationOf(*r)
+		staging, err := tx.Stage(destination)
+		util.Check(err, false)
+		for _, target := range skipped {
+			os.Remove(path.Join(staging, strings.TrimPrefix(target, destination)))
+			db.DropFile(*root, r, target)
+		}
+	}
+
+	if conflicts > 0 {
+		log.PrError("Found %d file conflicts! Aborting.", conflicts)
+	}
+
+	return claims.Takeovers
+}
+
+// applyTakeovers drops the paths in `takeovers` from the receipts of the
+// packages they were taken from, and writes them to `stageddb`.
+func applyTakeovers(takeovers map[string][]string, stageddb string) {
+	for name, targets := range takeovers {
+		r, ok, err := db.ReadReceipt(*dbdir, name)
+		util.Check(err, false)
+		if !ok {
+			continue
+		}
+
+		for _, target := range targets {
+			db.DropFile(*root, &r, target)
+		}
+
+		log.PrInfo("%d files of %s were taken over", len(targets), name)
+		err = db.WriteReceipt(stageddb, r)
+		util.Check(err, false)
+	}
+}
+
+// overwritePolicy returns the policy for the existing file `target`, set in the
+// manifest or otherwise by -overwrite.
+func overwritePolicy(target string) string {
+	if policy, ok := overwritePolicies[target]; ok {
+		return policy
+	}
+
+	return *overwrite
+}
+
+// identical checks if the existing `target` is the same as `file`.
+func identical(target string, file db.File) bool {
+	switch file.Type {
+	case db.TYPE_LINK:
+		link, err := os.Readlink(target)
+		return err == nil && link == file.Target
+	case db.TYPE_FILE:
+		sum, err := hash.FileSha256(target)
+		return err == nil && fmt.Sprintf("%x", sum) == file.Sha256
+	}
+
+	return false
+}
+
+// stagedBy returns the pending package which staged `target`, if any.
+func stagedBy(target string) *db.Receipt {
+	for _, r := range pending {
+		for _, file := range r.Files {
+			if file.Type != db.TYPE_DIR && db.Target(*root, *r, file) == target {
+				return r
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,37 +1,73 @@
 package main
 
 import (
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/RedCoolBeans/crane/util"
+	"github.com/RedCoolBeans/crane/util/db"
 	"github.com/RedCoolBeans/crane/util/fs"
 	g "github.com/RedCoolBeans/crane/util/git"
 	"github.com/RedCoolBeans/crane/util/gpg"
 	"github.com/RedCoolBeans/crane/util/hash"
+	"github.com/RedCoolBeans/crane/util/lfs"
 	log "github.com/RedCoolBeans/crane/util/logging"
 	m "github.com/RedCoolBeans/crane/util/manifest"
+	"github.com/RedCoolBeans/crane/util/policy"
 	"github.com/RedCoolBeans/crane/util/ssh"
+	"github.com/RedCoolBeans/crane/util/sshsig"
+	"github.com/RedCoolBeans/crane/util/users"
 	"gopkg.in/libgit2/git2go.v24"
 )
 
 var (
-	verbose   *bool
-	debug     *bool
-	silent    *bool
-	strict    *bool
-	pubkey    *string
-	signature *string
+	verbose    *bool
+	debug      *bool
+	silent     *bool
+	strict     *bool
+	pubkey     *string
+	signature  *string
+	submodules *bool
+	gitlfs     *bool
+	verifyRef  *bool
+	threshold  *int
+
+	allowedSigners *string
+	sshNamespace   *string
+
+	root           *string
+	dbdir          *string
+	allowDowngrade *bool
+
+	trustPolicy *policy.Policy
+
+	allHashes *bool
+	upgrade   *bool
+	overwrite *string
+	owner     *string
+
+	overwritePolicies = make(map[string]string)          // Per-file overwrite policies from the manifests
+	userDatabases     = make(map[string]*users.Database) // Users and groups per destination
+	manifestNames     = make(map[string]string)          // Manifest name of every package, by the name it was fetched as
+
+	tx      *fs.Transaction // Stages all packages until they're verified
+	pending []*db.Receipt   // Recorded once the transaction is committed
+	sigs    chan os.Signal  // Interrupts, checked for between steps
 )
 
 const (
-	HASH_ALGO      = "sha256"      // Default hashing algorithm used for verifying files
 	CRANE_HOME     = "/home/crane" // Default directory with SSH key
 	DEFAULT_BRANCH = "master"      // Default branch
 )
@@ -47,7 +83,7 @@ const (
 func main() {
 	cargo := flag.String("package", "", "Name of package to load")
 	branch := flag.String("branch", "master", "Branch or version")
-	destination := flag.String("destination", "/", "Destination for package on filesystem")
+	root = flag.String("destination", "/", "Destination for package on filesystem")
 	repo := flag.String("repo", "https://git.cargos.io/", "URI of repository base")
 	sshkey := flag.String("sshkey", "/home/crane/.ssh/id_rsa", "Path to SSH private key")
 	sshpass := flag.String("sshpass", "", "SSH private key password")
@@ -56,9 +92,24 @@ func main() {
 	clean := flag.Bool("clean", true, "Remove crane after deployment any SSH keys after deployment")
 	prefix := flag.String("prefix", "", "Prefix into the repository to the files")
 	strict = flag.Bool("strict", true, "Enable strict signature and checksum checking")
-	pubkey = flag.String("pubkey", "/home/crane/pubkey.asc", "Path to GPG public key")
+	pubkey = flag.String("pubkey", "/home/crane/pubkey.asc", "Path to GPG public key or directory of keys")
 	signature = flag.String("sig", "MANIFEST.yaml.sig", "Path to Manifest signature")
 	silent = flag.Bool("silent", true, "Wether to supress as much output as possible")
+	submodules = flag.Bool("submodules", false, "Recursively checkout git submodules")
+	gitlfs = flag.Bool("lfs", true, "Replace Git LFS pointer files with their objects")
+	verifyRef = flag.Bool("verify-ref", false, "Verify the GPG signature of the checked-out tag or commit instead of MANIFEST.yaml.sig")
+	threshold = flag.Int("sig-threshold", 1, "Number of distinct trusted keys required to have signed MANIFEST.yaml")
+	allowedSigners = flag.String("allowed-signers", "", "Path to allowed_signers file, verifies SSH signatures instead of GPG")
+	sshNamespace = flag.String("ssh-namespace", "crane", "Namespace SSH signatures have to be made in")
+	dbdir = flag.String("db", "/var/db/crane", "Path to package database, relative to -destination")
+	allowDowngrade = flag.Bool("allow-downgrade", false, "Allow installing an older version than was installed before")
+	policyFile := flag.String("trust-policy", "", "Path to trust policy binding signing keys to packages")
+	allHashes = flag.Bool("verify-all-hashes", false, "Verify every recorded checksum of a file, instead of only the strongest")
+	upgrade = flag.Bool("upgrade", false, "Remove files which were installed by the previous version of a package, but are no longer shipped")
+	overwrite = flag.String("overwrite", m.OVERWRITE_FAIL, "Policy for existing files not installed by crane: fail, overwrite, backup or skip-if-identical")
+	owner = flag.String("owner", "", "Default owner of installed files as user[:group], resolved within the destination")
+	jsonOutput := flag.Bool("json", false, "Output reports as JSON")
+	force := flag.Bool("force", false, "Remove packages even if other installed packages depend on them")
 
 	flag.Parse()
 
@@ -67,18 +118,91 @@ func main() {
 		*verbose = true
 	}
 
+	if err := fs.CanReadDir(*root, "Destination directory"); err != nil {
+		log.PrFatal(err.Error())
+	}
+
+	*dbdir = path.Join(*root, *dbdir)
+
+	switch flag.Arg(0) {
+	case "remove":
+		remove(flag.Args()[1:], *force)
+		return
+	case "verify":
+		verify(flag.Args()[1:], *jsonOutput)
+		return
+	case "list":
+		list(*jsonOutput)
+		return
+	case "info":
+		info(flag.Args()[1:], *jsonOutput)
+		return
+	case "owns":
+		owns(flag.Args()[1:], *jsonOutput)
+		return
+	}
+
 	if !gotCargo(*cargo) {
 		log.PrError("No package specified to load")
 	}
 
-	if err := fs.CanReadDir(*destination, "Destination directory"); err != nil {
-		log.PrFatal(err.Error())
+	if !m.IsOverwritePolicy(*overwrite) {
+		log.PrError("Invalid overwrite policy: %s", *overwrite)
+	}
+
+	if *threshold < 1 {
+		log.PrError("Invalid -sig-threshold=%d, at least one signature is required", *threshold)
+	}
+
+	// A tag or commit carries a single signature.
+	if *verifyRef && *threshold > 1 {
+		log.PrError("-sig-threshold=%d can't be met with -verify-ref, which verifies a single signature", *threshold)
+	}
+
+	if *policyFile != "" {
+		p, err := policy.ReadFile(*policyFile)
+		util.Check(err, false)
+		trustPolicy = p
 	}
 
 	chain := m.InitDependencyChain(*cargo)
 
+	// Nothing is installed into `destination` directly, but staged first and
+	// only moved into place when all packages have been verified. On any error
+	// or interrupt anything that was moved already is restored.
+	tx = fs.NewTransaction()
+	log.AtExit(tx.Rollback)
+
+	sigs = make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
 	// Everything is setup, hand-off to the main loop
-	crane(*repo, *cargo, *branch, *prefix, *destination, *sshkey, *sshpass, &chain)
+	crane(*repo, *cargo, *branch, *prefix, *root, *sshkey, *sshpass, &chain)
+	checkInterrupt()
+
+	for _, receipt := range pending {
+		resolveDependencies(receipt)
+		planConfigFiles(receipt)
+	}
+
+	takeovers := checkConflicts()
+	for _, receipt := range pending {
+		markCreatedDirs(receipt)
+		planUpgrade(receipt)
+	}
+
+	writeAccounts()
+	writeDatabase(takeovers)
+	checkInterrupt()
+
+	log.PrInfo("Committing installation")
+	if err := tx.Commit(); err != nil {
+		log.PrError("Could not commit installation, rolling back: %s", err)
+	}
+	checkInterrupt()
+
+	tx.Finish()
+	signal.Stop(sigs)
 
 	// Non-zero depth means we skipped a dependency somewhere?
 	// XXX: Hidden under -debug for now
@@ -91,6 +215,41 @@ func main() {
 	}
 }
 
+// checkInterrupt rolls back and exits if an interrupt was received.
+func checkInterrupt() {
+	select {
+	case sig := <-sigs:
+		log.PrError("Received %s, rolling back", sig)
+	default:
+	}
+}
+
+// writeDatabase stages the receipts and versions of all pending packages,
+// and those of the packages files were taken over from, so they're only
+// recorded when the transaction is committed.
+func writeDatabase(takeovers map[string][]string) {
+	staging, err := tx.Stage(*root)
+	util.Check(err, false)
+	stageddb := path.Join(staging, strings.TrimPrefix(*dbdir, path.Clean(*root)))
+
+	applyTakeovers(takeovers, stageddb)
+
+	versions, err := db.ReadVersions(*dbdir)
+	util.Check(err, false)
+
+	now := time.Now()
+	for _, receipt := range pending {
+		receipt.Installed = now
+		db.UpdateVersion(versions, receipt.Name, db.Version{Version: receipt.Version, Revision: receipt.Revision})
+
+		err = db.WriteReceipt(stageddb, *receipt)
+		util.Check(err, false)
+	}
+
+	err = db.WriteVersions(stageddb, versions)
+	util.Check(err, false)
+}
+
 func gotCargo(cargo string) bool {
 	if len(strings.TrimSpace(cargo)) < 1 {
 		return false
@@ -101,7 +260,6 @@ func gotCargo(cargo string) bool {
 
 func initGitOptions(sshOptions *ssh.SshOptions, branch string, repo string, cargo string) (*git.CloneOptions, string) {
 	options := &git.CloneOptions{}
-	options.CheckoutBranch = branch
 
 	var cargoRepo string
 
@@ -188,22 +346,47 @@ func crane(repo string, cargo string, branch string, prefix string, destination
 	log.PrInfo("Fetching %s (%s)...", cargo, branch)
 	err = g.Clone(cargoRepo, branch, clonedir, *options)
 	util.Check(err, false)
+	checkInterrupt()
 
-	if err := g.RemoveDotGit(clonedir); err != nil {
+	var signers []gpg.Signer
+	if *verifyRef {
+		signers = verifySignedRef(clonedir, branch, policyURL(repo+cargo))
+	} else if *strict {
+		signers = verifyManifest(clonedir, policyURL(repo+cargo))
+	}
+
+	commit, err := g.HeadCommit(clonedir)
+	util.Check(err, false)
+
+	manifest := parseManifest(clonedir)
+
+	// Submodules are checked out before removing .git so their files are
+	// subject to the same checksum verification as the rest of the package.
+	if *submodules || m.Submodules(manifest) {
+		log.PrInfo("Fetching submodules for %s...", cargo)
+		err = g.UpdateSubmodules(clonedir, *options)
+		util.Check(err, false)
+	}
+
+	if *gitlfs {
+		resolveLFS(cargoRepo, clonedir)
+	}
+
+	// Git only records the executable bit, which is lost with the .git directory.
+	modes, err := g.FileModes(clonedir)
+	if err != nil {
 		log.PrError(err.Error())
 	}
 
-	if *strict {
-		if ok, ids := gpg.Verify(*pubkey, *signature, clonedir, *verbose); ok {
-			log.PrInfoBegin("Signature for MANIFEST.yaml verified\n")
-			log.PrInfoEnd("Signed by: %s", strings.Join(ids, "\n\t"))
-		} else {
-			log.PrError("INVALID signature for MANIFEST.yaml! Aborting.")
-		}
+	if err := g.RemoveDotGit(clonedir); err != nil {
+		log.PrError(err.Error())
 	}
 
-	manifest := parseManifest(clonedir)
+	verifyTree(manifest, clonedir, prefix)
+	checkInterrupt()
+
 	log.PrInfo("Installing %s %s", manifest["name"], m.VersionString(manifest))
+	checkRollback(manifest)
 
 	parent := false
 	dependencies := m.Dependencies(manifest)
@@ -230,15 +413,48 @@ func crane(repo string, cargo string, branch string, prefix string, destination
 		log.PrInfo("Returning to installation of %s", cargo)
 	}
 
-	// A `destination` field in the manifest overrides the flag.
-	// If we're here for a dependency of the main entrypoint, it
-	// will override the `destination` variable on every iteration.
+	// A `destination` field in the manifest overrides the flag. It's
+	// interpreted relative to -destination, so a package for /usr/local
+	// ends up in /mnt/image/usr/local with -destination=/mnt/image.
+	recorded := recordedDestination(destination)
 	if manifestDest, ok := manifest["destination"].(string); ok {
-		destination = manifestDest
+		recorded = path.Join("/", manifestDest)
+	}
+
+	receipt := &db.Receipt{
+		Name:        fmt.Sprint(manifest["name"]),
+		Version:     m.Version(manifest),
+		Revision:    m.Revision(manifest),
+		Source:      redactURL(cargoRepo),
+		Commit:      commit,
+		Maintainer:  fmt.Sprint(manifest["maintainer"]),
+		Destination: recorded,
+		Replaces:    m.Replaces(manifest),
+	}
+	if email, ok := manifest["email"].(string); ok {
+		receipt.Email = email
+	}
+	if homepage, ok := manifest["homepage"].(string); ok {
+		receipt.Homepage = homepage
+	}
+	for _, d := range dependencies {
+		receipt.Dependencies = append(receipt.Dependencies, fmt.Sprint(d.(map[interface{}]interface{})["name"]))
+	}
+	for _, signer := range signers {
+		receipt.Signers = append(receipt.Signers, signer.Fingerprint)
 	}
+	destination = destinationOf(*receipt)
+	manifestNames[cargo] = receipt.Name
+
+	// Perform the actual installation into the staging area
+	staging, err := tx.Stage(destination)
+	util.Check(err, false)
 
-	// Perform the actual installation
-	installer(destination, clonedir, prefix)
+	// Users and groups have to exist before files can be owned by them.
+	createAccounts(manifest)
+	installer(staging, clonedir, prefix, receipt, modes)
+	pending = append(pending, receipt)
+	checkInterrupt()
 
 	// Housekeeping: mark the cargo as installed so we won't try to
 	// add it to the dependency list again.
@@ -247,7 +463,170 @@ func crane(repo string, cargo string, branch string, prefix string, destination
 	log.PrInfo("Cleaning for %s", cargo)
 }
 
-func install(destination string, clonedir string, contents []interface{}, ignore_patterns []interface{}) filepath.WalkFunc {
+// verifyTree recomputes the tree hash over everything that's about to be
+// installed and compares it to the one recorded in the manifest. This catches
+// files which were added, removed or modified without being listed in `contents`.
+func verifyTree(manifest map[interface{}]interface{}, clonedir string, prefix string) {
+	expected := m.TreeSha256(manifest)
+	if expected == "" {
+		log.PrVerbose(*verbose, "No tree_sha256 in manifest, skipping tree verification")
+		return
+	}
+
+	actual, err := hash.TreeSha256(path.Join(clonedir, prefix), clonedir, m.IgnorePatterns(manifest))
+	if err != nil {
+		log.PrError("Could not compute tree hash: %s", err)
+	}
+
+	if actual != expected {
+		log.PrError("Tree hash mismatch, the package contents differ from the manifest! Aborting.\n\tExpected: %s\n\tActual:   %s",
+			expected, actual)
+	}
+
+	log.PrVerbose(*verbose, "Tree hash %s verified", actual)
+}
+
+// Verify the signature(s) on MANIFEST.yaml, at least -sig-threshold distinct
+// keys allowed by the trust policy have to have signed it.
+func verifyManifest(clonedir string, repository string) []gpg.Signer {
+	var signers []gpg.Signer
+	if *allowedSigners != "" {
+		signers = verifySSHManifest(clonedir)
+	} else {
+		signers = gpg.VerifyAll(*pubkey, *signature, clonedir, *verbose)
+	}
+	signers = trustedSigners(parseManifest(clonedir), repository, signers)
+
+	if len(signers) < *threshold {
+		if *threshold > 1 {
+			log.PrError("Only %d of %d required signatures for MANIFEST.yaml are valid! Aborting.",
+				len(signers), *threshold)
+		}
+		log.PrError("INVALID signature for MANIFEST.yaml! Aborting.")
+	}
+
+	log.PrInfoBegin("Signature for MANIFEST.yaml verified\n")
+	printSigners(signers)
+
+	return signers
+}
+
+// Verify the SSH signature(s) on MANIFEST.yaml, the principals of the
+// allowed signers are reported as the signer's identities.
+func verifySSHManifest(clonedir string) []gpg.Signer {
+	signers := make([]gpg.Signer, 0)
+
+	for _, s := range sshsig.VerifyAll(*allowedSigners, *sshNamespace, *signature, clonedir, *verbose) {
+		signers = append(signers, gpg.Signer{
+			Identities:  s.Principals,
+			Fingerprint: s.Fingerprint,
+			Primary:     s.Fingerprint,
+		})
+	}
+
+	return signers
+}
+
+// Verify the signature on the checked-out tag or commit, this has to happen
+// before .git is removed.
+func verifySignedRef(clonedir string, branch string, repository string) []gpg.Signer {
+	signed, sig, what, err := g.SignedRef(clonedir, branch)
+	if err != nil {
+		log.PrError("Could not verify %s: %s", branch, err)
+	}
+
+	ok, signer := gpg.VerifyBytes(*pubkey, signed, sig, *verbose)
+	if !ok {
+		log.PrError("INVALID signature for %s! Aborting.", what)
+	}
+
+	signers := trustedSigners(parseManifest(clonedir), repository, []gpg.Signer{signer})
+	if len(signers) == 0 {
+		log.PrError("Signature for %s not allowed by trust policy! Aborting.", what)
+	}
+
+	log.PrInfoBegin("Signature for %s verified\n", what)
+	printSigners(signers)
+
+	return signers
+}
+
+// Drop the signers which aren't allowed to sign the package by the trust
+// policy. The package is identified by the name in its (signed) manifest,
+// rather than by the name it was fetched as.
+func trustedSigners(manifest map[interface{}]interface{}, repository string, signers []gpg.Signer) []gpg.Signer {
+	if trustPolicy == nil {
+		return signers
+	}
+
+	name := fmt.Sprint(manifest["name"])
+
+	allowed := make([]gpg.Signer, 0)
+	for _, signer := range signers {
+		if err := trustPolicy.Check(name, repository, signer.Fingerprints()); err != nil {
+			log.PrInfo("%s", err)
+			continue
+		}
+
+		allowed = append(allowed, signer)
+	}
+
+	return allowed
+}
+
+func printSigners(signers []gpg.Signer) {
+	for _, signer := range signers {
+		log.PrInfoEnd("Signed by: %s\n\tKey fingerprint: %s", strings.Join(signer.Identities, "\n\t"), signer.Fingerprint)
+	}
+}
+
+// Refuse expired manifests and releases older than what was installed before,
+// so an old (but validly signed) release can't be replayed.
+func checkRollback(manifest map[interface{}]interface{}) {
+	name := fmt.Sprint(manifest["name"])
+
+	expires, err := m.Expires(manifest)
+	util.Check(err, false)
+	if !expires.IsZero() && time.Now().After(expires) {
+		log.PrError("Manifest for %s expired on %s! Aborting.", name, expires.Format(time.RFC3339))
+	}
+
+	installed, ok, err := db.InstalledVersion(*dbdir, name)
+	util.Check(err, false)
+
+	if ok && m.CompareVersionRevision(m.Version(manifest), m.Revision(manifest), installed.Version, installed.Revision) < 0 {
+		current := m.VersionString(manifest)
+		previous := installed.Version
+		if installed.Revision != "" {
+			previous = fmt.Sprintf("%s rev. %s", installed.Version, installed.Revision)
+		}
+
+		if !*allowDowngrade {
+			log.PrError("Refusing to downgrade %s from %s to %s (use -allow-downgrade)", name, previous, current)
+		}
+		log.PrInfo("Downgrading %s from %s to %s", name, previous, current)
+	}
+}
+
+// Replace any Git LFS pointer files in `clonedir` with the actual objects,
+// so they can be verified and installed like any other file.
+func resolveLFS(cargoRepo string, clonedir string) {
+	pointers, err := lfs.FindPointers(clonedir)
+	util.Check(err, false)
+
+	if len(pointers) == 0 {
+		return
+	}
+
+	endpoint, creds, err := lfs.Endpoint(cargoRepo)
+	util.Check(err, false)
+
+	log.PrInfo("Fetching %d Git LFS object(s) from %s...", len(pointers), endpoint)
+	err = lfs.Resolve(pointers, endpoint, creds)
+	util.Check(err, false)
+}
+
+func install(destination string, clonedir string, contents []interface{}, ignore_patterns []interface{}, modes map[string]os.FileMode, accounts *users.Database, receipt *db.Receipt) filepath.WalkFunc {
 	first := true
 
 	log.PrVerbose(*verbose, "destination:%s, clonedir:%s", destination, clonedir)
@@ -270,11 +649,12 @@ func install(destination string, clonedir string, contents []interface{}, ignore
 		log.PrVerbose(*verbose, "fullsrc:%s, src:%s, installdir:%s, file:%s", fullsrc, src, installdir, file)
 
 		// First check if our current src is a file that will never be installed
-		for _, skipfile := range []string{".gitignore", "MANIFEST.yaml", "MANIFEST.yaml.sig", "README.md"} {
-			if file == skipfile {
-				log.PrVerbose(*verbose, "skipping %s", file)
-				return nil
+		if m.IsSkipped(file) {
+			log.PrVerbose(*verbose, "skipping %s", file)
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
 
 		// Now see if the file/directory or any of the parent directories are ignored
@@ -299,6 +679,14 @@ func install(destination string, clonedir string, contents []interface{}, ignore
 			}
 		}
 
+		// A file staged before by a package which replaces this one is kept.
+		if ft != DIR {
+			if other := stagedBy(path.Join(destinationOf(*receipt), src)); other != nil && db.Claim(*receipt, *other, true) == db.CLAIM_YIELD {
+				log.PrVerbose(*verbose, "Keeping %s of %s, it replaces %s", src, other.Name, receipt.Name)
+				return nil
+			}
+		}
+
 		// Skip checksum checks for directories
 		if ft == DIR {
 			if !*silent {
@@ -313,24 +701,6 @@ func install(destination string, clonedir string, contents []interface{}, ignore
 			// obvious reasons.
 			if ft == LINK {
 				log.PrVerbose(*verbose, "Symlink detected at %s, cowardly skipping checksum", fullsrc)
-			} else {
-				// Perform checksum verification on this file. If there's a hash recorded
-				// use it. If there is not and we're in strict mode, fail.
-				checksum := m.HashFor(contents, src, HASH_ALGO)
-				if *strict && checksum == "" {
-					log.PrError("No %s checksum found in manifest for %s", HASH_ALGO, src)
-				}
-
-				if ok := hash.Verify(contents, fullsrc, src, HASH_ALGO, *strict); !ok {
-					emsg := fmt.Sprintf("Checksum mismatch or absent for %s (%s)", src, HASH_ALGO)
-					// Checksum mismatch is not an error condition when in non-strict mode,
-					// however it's important enough to notify the user.
-					if *strict {
-						log.PrError(emsg)
-					} else {
-						log.PrInfo(emsg)
-					}
-				}
 			}
 		}
 
@@ -346,7 +716,7 @@ func install(destination string, clonedir string, contents []interface{}, ignore
 				fmt.Printf("Could not install symlink of %s -> %s\n", fullsrc, target)
 				return nil
 			}
-		} else {
+		} else if ft == DIR {
 			// fullsrc is the full path to the git cloned file,
 			// src is the file we're installing as/to (e.g. /usr/pkg/...)
 			if err := fs.Install(fullsrc, src, destination, *verbose); err != nil {
@@ -354,28 +724,268 @@ func install(destination string, clonedir string, contents []interface{}, ignore
 			}
 		}
 
-		// Finally set the mode for the full path to the final, on-disk copy of the file
-		if mode := m.ModeFor(contents, src, ft == DIR); mode > 0 {
-			os.Chmod(path.Join(destination, src), os.FileMode(mode))
+		var sum string
+		if ft == FILE {
+			sum = installFile(contents, fullsrc, src, destination)
+		}
+
+		// Finally set the mode for the full path to the final, on-disk copy of the file.
+		// Files without a mode in the manifest keep the one recorded in git.
+		if mode := m.ModeFor(contents, src, int(modes[src])); mode > 0 {
+			tx.Chmod(path.Join(destination, src), os.FileMode(mode))
+		}
+
+		// The default owner only applies to directories created by the package,
+		// existing directories only change owner if the manifest says so.
+		if uid, gid, explicit := ownerFor(contents, src, accounts); uid != -1 || gid != -1 {
+			staged := path.Join(destination, src)
+			if ft == DIR && !explicit {
+				err = os.Lchown(staged, uid, gid)
+			} else {
+				err = tx.Chown(staged, uid, gid)
+			}
+			if err != nil {
+				log.PrError("Could not change owner of %s: %s", src, err)
+			}
+		}
+
+		installed := receiptFile(path.Join(destination, src), src, ft, sum)
+		installed.Config = ft == FILE && m.IsConfig(contents, src)
+		receipt.Files = append(receipt.Files, installed)
+
+		if policy := m.OverwriteFor(contents, src); policy != "" {
+			if !m.IsOverwritePolicy(policy) {
+				log.PrError("Invalid overwrite policy for %s: %s", src, policy)
+			}
+			overwritePolicies[path.Join(destinationOf(*receipt), src)] = policy
 		}
 
 		return nil
 	}
 }
 
-func installer(destination string, clonedir string, prefix string) {
+// installFile copies `fullsrc` to `src` within `destination`, verifying the
+// checksum while copying. If there's a hash recorded use the strongest one
+// (or all of them). If there is not and we're in strict mode, fail.
+// The SHA256 of the installed file is returned for its receipt.
+func installFile(contents []interface{}, fullsrc string, src string, destination string) string {
+	algos := hash.Present(contents, src)
+	if len(algos) == 0 {
+		if *strict {
+			log.PrError("No checksum found in manifest for %s", src)
+		}
+	} else if !*allHashes {
+		algos = algos[:1]
+	}
+
+	verifier, err := hash.NewVerifier(algos)
+	if err != nil {
+		log.PrError(err.Error())
+	}
+
+	sum := sha256.New()
+	err = fs.CopyFileVerified(fullsrc, path.Join(destination, src), 0644, io.MultiWriter(verifier, sum), func() error {
+		mismatches := verifier.Mismatches(contents, src)
+		if len(algos) == 0 {
+			mismatches = []string{hash.DEFAULT_ALGO}
+		}
+
+		if len(mismatches) == 0 {
+			return nil
+		}
+
+		emsg := fmt.Sprintf("Checksum mismatch or absent for %s (%s)", src, strings.Join(mismatches, ", "))
+		// Checksum mismatch is not an error condition when in non-strict mode,
+		// however it's important enough to notify the user.
+		if *strict {
+			return errors.New(emsg)
+		}
+
+		log.PrInfo(emsg)
+		return nil
+	})
+	if err != nil {
+		log.PrError("Could not install %s into %s: %s", src, destination, err)
+	}
+
+	return fmt.Sprintf("%x", sum.Sum(nil))
+}
+
+// receiptFile describes the `staged` copy of `src` for the package database.
+func receiptFile(staged string, src string, ft Filetype, sum string) db.File {
+	file := db.File{Path: src, Sha256: sum}
+
+	info, err := os.Lstat(staged)
+	if err != nil {
+		log.PrError("Could not stat %s: %s", staged, err)
+	}
+	file.Mode = info.Mode().Perm()
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		file.Uid = int(stat.Uid)
+		file.Gid = int(stat.Gid)
+	}
+
+	switch ft {
+	case DIR:
+		file.Type = db.TYPE_DIR
+	case LINK:
+		file.Type = db.TYPE_LINK
+		file.Target, _ = os.Readlink(staged)
+	default:
+		file.Type = db.TYPE_FILE
+	}
+
+	return file
+}
+
+// recordedDestination returns `destination` relative to -destination, as
+// it's recorded in the receipt; the database may be used from within the
+// root filesystem later on, i.e. when it's an image.
+func recordedDestination(destination string) string {
+	abs, err := filepath.Abs(destination)
+	util.Check(err, false)
+	rootdir, err := filepath.Abs(*root)
+	util.Check(err, false)
+
+	rel, err := filepath.Rel(rootdir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		log.PrError("Destination %s is outside of -destination %s", destination, *root)
+	}
+
+	return path.Join("/", rel)
+}
+
+// destinationOf returns the directory the files of `r` are installed into.
+func destinationOf(r db.Receipt) string {
+	return path.Join(*root, r.Destination)
+}
+
+// resolveDependencies replaces the dependencies of `receipt`, which are
+// fetched by the name in the manifest of the package depending on them, with
+// the names in their own manifests, so they match their receipts.
+func resolveDependencies(receipt *db.Receipt) {
+	for i, dep := range receipt.Dependencies {
+		if name, ok := manifestNames[dep]; ok {
+			receipt.Dependencies[i] = name
+		}
+	}
+}
+
+// markCreatedDirs marks the directories in `receipt` which don't exist yet,
+// or which were created by the previous version of the package. Existing
+// directories keep their mode and owner unless the manifest sets them, which
+// is what's recorded then.
+func markCreatedDirs(receipt *db.Receipt) {
+	previous, _, err := db.ReadReceipt(*dbdir, receipt.Name)
+	util.Check(err, false)
+
+	created := make(map[string]bool)
+	for _, file := range previous.Files {
+		created[path.Join(destinationOf(previous), file.Path)] = file.Created
+	}
+
+	staging, err := tx.Stage(destinationOf(*receipt))
+	util.Check(err, false)
+
+	for i, file := range receipt.Files {
+		if file.Type != db.TYPE_DIR {
+			continue
+		}
+
+		target := path.Join(destinationOf(*receipt), file.Path)
+		info, err := os.Stat(target)
+		if os.IsNotExist(err) || created[target] {
+			receipt.Files[i].Created = true
+		}
+		if err != nil {
+			continue
+		}
+
+		// Symlinks to directories are left alone altogether, see fs.Transaction.
+		mode, owner := tx.Changes(path.Join(staging, file.Path))
+		if link, err := os.Lstat(target); err == nil && link.Mode()&os.ModeSymlink != 0 {
+			mode, owner = false, false
+		}
+
+		if !mode {
+			receipt.Files[i].Mode = info.Mode().Perm()
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && !owner {
+			receipt.Files[i].Uid = int(stat.Uid)
+			receipt.Files[i].Gid = int(stat.Gid)
+		}
+	}
+}
+
+// ownerFor returns the uid and gid for `src` as set in `contents`, falling
+// back to -owner, resolved within the destination; either is -1 if unset.
+// `explicit` is set if the manifest sets the owner or group.
+func ownerFor(contents []interface{}, src string, accounts *users.Database) (uid int, gid int, explicit bool) {
+	user, group := m.OwnerFor(contents, src)
+	explicit = user != "" || group != ""
+
+	// An owner without a group in the manifest gets the owner's primary group.
+	if user == "" {
+		defaults := strings.SplitN(*owner, ":", 2)
+		user = defaults[0]
+		if group == "" && len(defaults) > 1 {
+			group = defaults[1]
+		}
+	}
+
+	uid, gid, err := accounts.Resolve(user, group)
+	if err != nil {
+		log.PrError("Could not resolve owner of %s: %s", src, err)
+	}
+
+	return uid, gid, explicit
+}
+
+// redactURL strips any password from `uri`, so it can be recorded.
+func redactURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil {
+		return uri
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.User(u.User.Username())
+	}
+
+	return u.String()
+}
+
+// policyURL strips any credentials from an HTTP(S) `uri`, so it's matched
+// against the trust policy and reported without them. The user of SSH
+// repositories is kept, as it's part of how they're written down.
+func policyURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return redactURL(uri)
+	}
+
+	u.User = nil
+	return u.String()
+}
+
+func installer(destination string, clonedir string, prefix string, receipt *db.Receipt, modes map[string]os.FileMode) {
 	manifest := parseManifest(clonedir)
 	contents := m.Contents(manifest)
 	ignores := m.IgnorePatterns(manifest)
 
-	err := filepath.Walk(path.Join(clonedir, prefix), install(destination, clonedir, contents, ignores))
+	// Owners are resolved against the accounts of -destination, not those of
+	// the staging directory or the package's own destination.
+	accounts := accountsFor(*root)
+
+	err := filepath.Walk(path.Join(clonedir, prefix), install(destination, clonedir, contents, ignores, modes, accounts, receipt))
 	if err != nil {
 		log.PrError("Install failed: %s", err.Error)
 	}
 }
 
 func parseManifest(clonedir string) map[interface{}]interface{} {
-	manifestFile := path.Join(clonedir, "MANIFEST.yaml")
+	manifestFile := path.Join(clonedir, m.MANIFEST)
 	if err := fs.CanReadFile(manifestFile, "MANIFEST file"); err != nil {
 		log.PrError(err.Error())
 	}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RedCoolBeans/crane/util"
+	"github.com/RedCoolBeans/crane/util/db"
+	log "github.com/RedCoolBeans/crane/util/logging"
+)
+
+// listEntry is what `list` reports per package.
+type listEntry struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Revision  string    `json:"revision,omitempty"`
+	Source    string    `json:"source"`
+	Commit    string    `json:"commit,omitempty"`
+	Installed time.Time `json:"installed"`
+}
+
+// ownsEntry is what `owns` reports per path.
+type ownsEntry struct {
+	Path     string   `json:"path"`
+	Packages []string `json:"packages"`
+}
+
+// list shows all installed packages.
+func list(asJSON bool) {
+	receipts, err := db.Receipts(*dbdir)
+	util.Check(err, false)
+
+	if asJSON {
+		entries := make([]listEntry, 0)
+		for _, r := range receipts {
+			entries = append(entries, listEntry{r.Name, r.Version, r.Revision, r.Source, r.Commit, r.Installed})
+		}
+		printJSON(entries)
+		return
+	}
+
+	for _, r := range receipts {
+		fmt.Printf("%s %s (%s)\n", r.Name, r, shortCommit(r.Commit))
+	}
+}
+
+// info shows the metadata and files of the packages `names`.
+func info(names []string, asJSON bool) {
+	if len(names) == 0 {
+		log.PrError("No package specified")
+	}
+
+	receipts := make([]db.Receipt, 0)
+	for _, name := range names {
+		r, ok, err := db.ReadReceipt(*dbdir, name)
+		util.Check(err, false)
+		if !ok {
+			log.PrError("Package %s is not installed", name)
+		}
+		receipts = append(receipts, r)
+	}
+
+	if asJSON {
+		printJSON(receipts)
+		return
+	}
+
+	for _, r := range receipts {
+		log.PrInfo("%s %s", r.Name, r)
+		if r.Email != "" {
+			fmt.Printf("Maintainer:   %s <%s>\n", r.Maintainer, r.Email)
+		} else {
+			fmt.Printf("Maintainer:   %s\n", r.Maintainer)
+		}
+		if r.Homepage != "" {
+			fmt.Printf("Homepage:     %s\n", r.Homepage)
+		}
+		fmt.Printf("Source:       %s\n", r.Source)
+		fmt.Printf("Commit:       %s\n", r.Commit)
+		fmt.Printf("Signed by:    %s\n", strings.Join(r.Signers, ", "))
+		fmt.Printf("Dependencies: %s\n", strings.Join(r.Dependencies, ", "))
+		fmt.Printf("Destination:  %s\n", r.Destination)
+		fmt.Printf("Installed:    %s\n", r.Installed.Format(time.RFC3339))
+		fmt.Printf("Files:\n")
+		for _, file := range r.Files {
+			switch file.Type {
+			case db.TYPE_DIR:
+				fmt.Printf("\t%s/\n", file.Path)
+			case db.TYPE_LINK:
+				fmt.Printf("\t%s -> %s\n", file.Path, file.Target)
+			default:
+				fmt.Printf("\t%s\n", file.Path)
+			}
+		}
+	}
+}
+
+// owns shows which packages own the `paths`, which may be given relative to
+// `destination`. Exits non-zero if any path isn't owned by a package.
+func owns(paths []string, asJSON bool) {
+	if len(paths) == 0 {
+		log.PrError("No path specified")
+	}
+
+	receipts, err := db.Receipts(*dbdir)
+	util.Check(err, false)
+
+	entries := make([]ownsEntry, 0)
+	unowned := 0
+
+	for _, p := range paths {
+		entry := ownsEntry{Path: p, Packages: db.Owners(*root, receipts, p)}
+
+		if len(entry.Packages) == 0 {
+			unowned++
+		}
+		entries = append(entries, entry)
+	}
+
+	if asJSON {
+		printJSON(entries)
+	} else {
+		for _, entry := range entries {
+			if len(entry.Packages) == 0 {
+				fmt.Printf("%s is not owned by any package\n", entry.Path)
+			} else {
+				fmt.Printf("%s is owned by %s\n", entry.Path, strings.Join(entry.Packages, ", "))
+			}
+		}
+	}
+
+	if unowned > 0 {
+		os.Exit(1)
+	}
+}
+
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	util.Check(err, false)
+	fmt.Println(string(out))
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+
+	return commit
+}
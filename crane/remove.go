@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/RedCoolBeans/crane/util"
+	"github.com/RedCoolBeans/crane/util/db"
+	"github.com/RedCoolBeans/crane/util/hash"
+	log "github.com/RedCoolBeans/crane/util/logging"
+)
+
+// remove uninstalls the packages `names` using their receipts. Packages which
+// other installed packages depend on are only removed when `force` is set.
+func remove(names []string, force bool) {
+	if len(names) == 0 {
+		log.PrError("No package specified to remove")
+	}
+
+	receipts, err := db.Receipts(*dbdir)
+	util.Check(err, false)
+
+	removing := make(map[string]bool)
+	for _, name := range names {
+		removing[name] = true
+	}
+
+	for _, name := range names {
+		receipt, ok, err := db.ReadReceipt(*dbdir, name)
+		util.Check(err, false)
+		if !ok {
+			log.PrError("Package %s is not installed", name)
+		}
+
+		if dependents := db.Dependents(receipts, name, removing); len(dependents) > 0 {
+			if !force {
+				log.PrError("Refusing to remove %s, it's required by: %s (use -force)", name, strings.Join(dependents, ", "))
+			}
+			log.PrInfo("Removing %s, even though it's required by: %s", name, strings.Join(dependents, ", "))
+		}
+
+		removePackage(receipt, db.Owned(*root, receipts, removing))
+	}
+}
+
+// removePackage deletes the files recorded in `receipt`, except those in
+// `owned`, followed by the directories it created once they're empty.
+func removePackage(receipt db.Receipt, owned map[string]bool) {
+	log.PrInfo("Removing %s %s", receipt.Name, receipt.Version)
+
+	dirs := make([]string, 0)
+
+	for _, file := range receipt.Files {
+		target := db.Target(*root, receipt, file)
+
+		if owned[target] {
+			log.PrVerbose(*verbose, "Keeping %s, it's owned by another package", target)
+			continue
+		}
+
+		// Directories which existed before the package was installed are kept.
+		if file.Type == db.TYPE_DIR {
+			if file.Created {
+				dirs = append(dirs, target)
+			}
+			continue
+		}
+
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			log.PrVerbose(*verbose, "%s was already removed", target)
+			continue
+		}
+
+		if file.Type == db.TYPE_FILE && file.Sha256 != "" {
+			if sum, err := hash.FileSha256(target); err == nil && fmt.Sprintf("%x", sum) != file.Sha256 {
+				log.PrInfo("Warning: %s was modified since it was installed", target)
+			}
+		}
+
+		if !*silent {
+			log.PrInfo2("Removing %s", target)
+		}
+		if err := os.Remove(target); err != nil {
+			log.PrError("Could not remove %s: %s", target, err)
+		}
+
+		// Put back the original file which was replaced when installing.
+		if file.Orig != "" {
+			orig := path.Join(destinationOf(receipt), file.Orig)
+			if err := os.Rename(orig, target); err != nil {
+				log.PrInfo("Could not restore %s from %s: %s", target, orig, err)
+			} else {
+				log.PrInfo("Restored original %s", target)
+			}
+		}
+	}
+
+	// Remove the deepest directories first, so their parents may become empty.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if err := os.Remove(dir); err != nil {
+			log.PrVerbose(*verbose, "Keeping %s: %s", dir, err)
+		} else if !*silent {
+			log.PrInfo2("Removing %s/", dir)
+		}
+	}
+
+	err := db.RemoveReceipt(*dbdir, receipt.Name)
+	util.Check(err, false)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path"
+
+	"github.com/RedCoolBeans/crane/util"
+	"github.com/RedCoolBeans/crane/util/db"
+	log "github.com/RedCoolBeans/crane/util/logging"
+)
+
+// planUpgrade compares the files of the package about to be installed with
+// those recorded for its previous version, and reports how many are added,
+// changed and removed. With -upgrade the paths which are no longer shipped
+// (and aren't owned by any other package) are removed when committing.
+func planUpgrade(receipt *db.Receipt) {
+	previous, ok, err := db.ReadReceipt(*dbdir, receipt.Name)
+	util.Check(err, false)
+	if !ok {
+		return
+	}
+
+	receipts, err := db.Receipts(*dbdir)
+	util.Check(err, false)
+	owned := db.Owned(*root, append(receipts, pendingReceipts()...), map[string]bool{receipt.Name: true})
+
+	u := db.PlanUpgrade(*root, previous, receipt, owned)
+	if *upgrade {
+		for _, file := range u.Stale {
+			log.PrVerbose(*verbose, "Removing obsolete %s", db.Target(*root, previous, file))
+			if file.Orig != "" {
+				err = tx.Restore(destinationOf(previous), file.Path, path.Join(destinationOf(previous), file.Orig))
+			} else {
+				err = tx.Remove(destinationOf(previous), file.Path)
+			}
+			util.Check(err, false)
+		}
+	}
+
+	log.PrInfo("Upgrading %s from %s to %s: %d added, %d changed, %d removed",
+		receipt.Name, previous, receipt, u.Added, u.Changed, u.Removed)
+	if u.Removed > 0 && !*upgrade {
+		log.PrInfo("Leaving %d files from the previous version of %s behind (use -upgrade)", u.Removed, receipt.Name)
+	}
+}
+
+// pendingReceipts returns the receipts of all packages being installed.
+func pendingReceipts() []db.Receipt {
+	receipts := make([]db.Receipt, 0)
+	for _, r := range pending {
+		receipts = append(receipts, *r)
+	}
+
+	return receipts
+}
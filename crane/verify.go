@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/RedCoolBeans/crane/util"
+	"github.com/RedCoolBeans/crane/util/db"
+	log "github.com/RedCoolBeans/crane/util/logging"
+)
+
+// verify checks the packages `names` (or all installed packages) against
+// their receipts, and exits non-zero if anything differs.
+func verify(names []string, asJSON bool) {
+	all, err := db.Receipts(*dbdir)
+	util.Check(err, false)
+
+	receipts := all
+	if len(names) > 0 {
+		receipts = make([]db.Receipt, 0)
+		for _, name := range names {
+			receipt, ok, err := db.ReadReceipt(*dbdir, name)
+			util.Check(err, false)
+			if !ok {
+				log.PrError("Package %s is not installed", name)
+			}
+			receipts = append(receipts, receipt)
+		}
+	}
+
+	owned := db.Owned(*root, all, nil)
+	discrepancies := make([]db.Discrepancy, 0)
+
+	for _, receipt := range receipts {
+		discrepancies = append(discrepancies, db.Verify(*root, *dbdir, receipt, owned)...)
+	}
+
+	if asJSON {
+		printJSON(discrepancies)
+	} else {
+		for _, d := range discrepancies {
+			msg := fmt.Sprintf("%s: %s %s", d.Package, d.Path, d.Kind)
+			if d.Expected != "" || d.Actual != "" {
+				msg += fmt.Sprintf(" (expected: %s, actual: %s)", d.Expected, d.Actual)
+			}
+			log.PrInfo2("%s", msg)
+		}
+	}
+
+	if len(discrepancies) > 0 {
+		if !asJSON {
+			log.PrInfo("Found %d discrepancies in %d packages", len(discrepancies), len(receipts))
+		}
+		os.Exit(1)
+	}
+
+	if !asJSON {
+		log.PrInfo("Verified %d packages", len(receipts))
+	}
+}
@@ -0,0 +1,109 @@
+package db
+
+import "fmt"
+
+const (
+	CLAIM_CONFLICT = iota // Neither package may take the path from the other
+	CLAIM_TAKE            // The package being installed takes the path
+	CLAIM_YIELD           // The owner keeps the path
+)
+
+// Claim decides which of two packages gets a path they both ship: `r`, which
+// is being installed, or `owner`, which owns it already. A package takes the
+// paths of the packages it replaces, and of its own previous version. If
+// `owner` is being installed along with `r` (`pending`), it equally keeps the
+// paths of the packages it replaces; an installed package doesn't, as it's
+// `r` that's being installed after it.
+func Claim(r Receipt, owner Receipt, pending bool) int {
+	switch {
+	case r.Name == owner.Name, replaces(r, owner.Name):
+		return CLAIM_TAKE
+	case pending && replaces(owner, r.Name):
+		return CLAIM_YIELD
+	}
+
+	return CLAIM_CONFLICT
+}
+
+func replaces(r Receipt, name string) bool {
+	for _, replaced := range r.Replaces {
+		if replaced == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Claims is the outcome of ClaimPaths.
+type Claims struct {
+	Takeovers map[string][]string // Paths taken over from installed packages, by package name
+	Conflicts []string            // Paths which neither package may take, described
+}
+
+// ClaimPaths decides which package gets every path below `root` that's
+// shipped by more than one of the `pending` packages, or by one of them and
+// an `installed` package, see Claim. Paths a pending package has to give up
+// are dropped from its receipt. Directories may be shared.
+func ClaimPaths(root string, installed []Receipt, pending []*Receipt) Claims {
+	claims := Claims{Takeovers: make(map[string][]string), Conflicts: make([]string, 0)}
+
+	installing := make(map[string]bool)
+	for _, r := range pending {
+		installing[r.Name] = true
+	}
+
+	// Paths owned by the previous version of a package which is installed
+	// again are its own to replace.
+	owners := make(map[string]Receipt)
+	for _, r := range installed {
+		for _, file := range r.Files {
+			if file.Type != TYPE_DIR && !installing[r.Name] {
+				owners[Target(root, r, file)] = r
+			}
+		}
+	}
+
+	staged := make(map[string]*Receipt)
+	for _, r := range pending {
+		for _, file := range r.Files {
+			if file.Type == TYPE_DIR {
+				continue
+			}
+			target := Target(root, *r, file)
+
+			if other, ok := staged[target]; ok && other.Name != r.Name {
+				switch Claim(*r, *other, true) {
+				case CLAIM_TAKE:
+					DropFile(root, other, target)
+				case CLAIM_CONFLICT:
+					claims.Conflicts = append(claims.Conflicts,
+						fmt.Sprintf("%s is shipped by both %s and %s", target, other.Name, r.Name))
+				}
+			}
+			staged[target] = r
+
+			if owner, ok := owners[target]; ok {
+				if Claim(*r, owner, false) == CLAIM_TAKE {
+					claims.Takeovers[owner.Name] = append(claims.Takeovers[owner.Name], target)
+				} else {
+					claims.Conflicts = append(claims.Conflicts,
+						fmt.Sprintf("%s of %s is owned by %s", target, r.Name, owner.Name))
+				}
+			}
+		}
+	}
+
+	return claims
+}
+
+// DropFile removes `target`, below `root`, from the files of `r`.
+func DropFile(root string, r *Receipt, target string) {
+	files := make([]File, 0, len(r.Files))
+	for _, file := range r.Files {
+		if Target(root, *r, file) != target {
+			files = append(files, file)
+		}
+	}
+	r.Files = files
+}
@@ -0,0 +1,104 @@
+package db_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/db"
+)
+
+func TestClaim(t *testing.T) {
+	tool := db.Receipt{Name: "tool"}
+	toolng := db.Receipt{Name: "tool-ng", Replaces: []string{"tool"}}
+	other := db.Receipt{Name: "other"}
+
+	var tests = []struct {
+		r       db.Receipt
+		owner   db.Receipt
+		pending bool
+		out     int
+	}{
+		{tool, tool, false, db.CLAIM_TAKE},
+		{tool, other, false, db.CLAIM_CONFLICT},
+		{tool, other, true, db.CLAIM_CONFLICT},
+		{toolng, tool, false, db.CLAIM_TAKE},
+		{toolng, tool, true, db.CLAIM_TAKE},
+		{tool, toolng, true, db.CLAIM_YIELD},
+		{tool, toolng, false, db.CLAIM_CONFLICT},
+		{toolng, other, true, db.CLAIM_CONFLICT},
+	}
+
+	for i, tt := range tests {
+		if c := db.Claim(tt.r, tt.owner, tt.pending); c != tt.out {
+			t.Errorf("%d. %s claiming from %s (pending: %v) => %d, wanted: %d", i, tt.r.Name, tt.owner.Name, tt.pending, c, tt.out)
+		}
+	}
+}
+
+func TestClaimPaths(t *testing.T) {
+	file := func(p string) db.File { return db.File{Path: p, Type: db.TYPE_FILE} }
+	dir := func(p string) db.File { return db.File{Path: p, Type: db.TYPE_DIR} }
+
+	installed := []db.Receipt{
+		{Name: "tool", Destination: "/", Files: []db.File{dir("/usr/bin"), file("/usr/bin/tool")}},
+		{Name: "other", Destination: "/", Files: []db.File{dir("/usr/bin"), file("/usr/bin/other")}},
+	}
+
+	var tests = []struct {
+		pending   []db.Receipt
+		takeovers map[string][]string
+		conflicts int
+		files     []int // number of files of each pending package afterwards
+	}{
+		// A new version of an installed package, and a shared directory
+		{[]db.Receipt{
+			{Name: "tool", Destination: "/", Files: []db.File{dir("/usr/bin"), file("/usr/bin/tool")}},
+		}, map[string][]string{}, 0, []int{2}},
+		// An installed package's file
+		{[]db.Receipt{
+			{Name: "third", Destination: "/usr", Files: []db.File{file("/bin/tool")}},
+		}, map[string][]string{}, 1, []int{1}},
+		// ...which it replaces
+		{[]db.Receipt{
+			{Name: "tool-ng", Destination: "/usr", Files: []db.File{file("/bin/tool")}, Replaces: []string{"tool"}},
+		}, map[string][]string{"tool": {"/usr/bin/tool"}}, 0, []int{1}},
+		// Two pending packages
+		{[]db.Receipt{
+			{Name: "a", Destination: "/", Files: []db.File{file("/opt/a")}},
+			{Name: "b", Destination: "/", Files: []db.File{file("/opt/a"), file("/opt/b")}},
+		}, map[string][]string{}, 1, []int{1, 2}},
+		// ...of which the latter replaces the former
+		{[]db.Receipt{
+			{Name: "a", Destination: "/", Files: []db.File{file("/opt/a")}},
+			{Name: "b", Destination: "/", Files: []db.File{file("/opt/a"), file("/opt/b")}, Replaces: []string{"a"}},
+		}, map[string][]string{}, 0, []int{0, 2}},
+		// ...of which the former replaces the latter, which yields
+		{[]db.Receipt{
+			{Name: "a", Destination: "/", Files: []db.File{file("/opt/a")}, Replaces: []string{"b"}},
+			{Name: "b", Destination: "/", Files: []db.File{file("/opt/a"), file("/opt/b")}},
+		}, map[string][]string{}, 0, []int{1, 2}},
+	}
+
+	for i, tt := range tests {
+		pending := make([]*db.Receipt, 0)
+		for j := range tt.pending {
+			r := tt.pending[j]
+			r.Files = append([]db.File{}, r.Files...)
+			pending = append(pending, &r)
+		}
+
+		claims := db.ClaimPaths("/", installed, pending)
+
+		if !reflect.DeepEqual(claims.Takeovers, tt.takeovers) {
+			t.Errorf("%d. takeovers => %v, wanted: %v", i, claims.Takeovers, tt.takeovers)
+		}
+		if len(claims.Conflicts) != tt.conflicts {
+			t.Errorf("%d. conflicts => %v, wanted %d", i, claims.Conflicts, tt.conflicts)
+		}
+		for j, r := range pending {
+			if len(r.Files) != tt.files[j] {
+				t.Errorf("%d. %s => %d files, wanted: %d", i, r.Name, len(r.Files), tt.files[j])
+			}
+		}
+	}
+}
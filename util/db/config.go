@@ -0,0 +1,72 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/RedCoolBeans/crane/util/hash"
+)
+
+const CONFIG_NEW_SUFFIX = ".crane-new" // New version of a locally modified configuration file
+
+const (
+	CONFIG_INSTALL   = iota // The file is absent and is installed
+	CONFIG_UNCHANGED        // The file is the new version already
+	CONFIG_UPDATE           // The file wasn't modified and is replaced
+	CONFIG_KEEP             // The file was modified and is kept, the new version is installed next to it
+)
+
+// PlanConfigFiles decides what happens to every configuration file of
+// `receipt`, keyed by its path. A configuration file is installed if it's
+// absent below `root`, and replaced if it still matches the hash recorded in
+// the `previous` receipt. If it was modified locally (or wasn't installed by
+// Crane at all) it's kept, and the new version is installed as
+// PATH.crane-new instead.
+func PlanConfigFiles(root string, previous Receipt, receipt Receipt) (map[string]int, error) {
+	recorded := make(map[string]string)
+	for _, file := range previous.Files {
+		recorded[Target(root, previous, file)] = file.Sha256
+	}
+
+	plan := make(map[string]int)
+	for _, file := range receipt.Files {
+		if !file.Config {
+			continue
+		}
+		target := Target(root, receipt, file)
+
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			plan[file.Path] = CONFIG_INSTALL
+			continue
+		}
+
+		sum, err := hash.FileSha256(target)
+		if err != nil {
+			e := fmt.Sprintf("Could not calculate hash for %s: %s", target, err)
+			return nil, errors.New(e)
+		}
+		current := fmt.Sprintf("%x", sum)
+
+		switch {
+		case current == file.Sha256:
+			plan[file.Path] = CONFIG_UNCHANGED
+		case current == recorded[target]:
+			plan[file.Path] = CONFIG_UPDATE
+		default:
+			plan[file.Path] = CONFIG_KEEP
+		}
+	}
+
+	return plan, nil
+}
+
+// NewConfigFile returns the file for the new version of the configuration
+// file `file`, which is installed next to it when it's kept. The recorded
+// hash stays that of the new version, so the local modifications are kept
+// on later upgrades too.
+func NewConfigFile(file File) File {
+	file.Path += CONFIG_NEW_SUFFIX
+	file.Config = false
+	return file
+}
@@ -0,0 +1,62 @@
+package db_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/db"
+)
+
+func TestPlanConfigFiles(t *testing.T) {
+	sum := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+
+	var tests = []struct {
+		current  string // contents of etc/tool.conf on disk, if any
+		recorded string // contents recorded for the previous version, if any
+		out      int
+	}{
+		{"", "", db.CONFIG_INSTALL},
+		{"", "old", db.CONFIG_INSTALL},
+		{"new", "old", db.CONFIG_UNCHANGED},
+		{"new", "", db.CONFIG_UNCHANGED},
+		{"old", "old", db.CONFIG_UPDATE},
+		{"local", "old", db.CONFIG_KEEP},
+		{"local", "", db.CONFIG_KEEP},
+	}
+
+	for i, tt := range tests {
+		root, err := ioutil.TempDir("", "crane-config")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+
+		os.MkdirAll(path.Join(root, "opt/tool/etc"), 0755)
+		if tt.current != "" {
+			ioutil.WriteFile(path.Join(root, "opt/tool/etc/tool.conf"), []byte(tt.current), 0644)
+		}
+
+		previous := db.Receipt{Name: "tool", Destination: "/opt/tool"}
+		if tt.recorded != "" {
+			previous.Files = []db.File{{Path: "/etc/tool.conf", Type: db.TYPE_FILE, Sha256: sum(tt.recorded), Config: true}}
+		}
+		receipt := db.Receipt{Name: "tool", Destination: "/opt/tool", Files: []db.File{
+			{Path: "/etc/tool.conf", Type: db.TYPE_FILE, Sha256: sum("new"), Config: true},
+			{Path: "/bin/tool", Type: db.TYPE_FILE, Sha256: sum("tool")},
+		}}
+
+		plan, err := db.PlanConfigFiles(root, previous, receipt)
+		if err != nil {
+			t.Errorf("%d. %s", i, err)
+			continue
+		}
+
+		if len(plan) != 1 || plan["/etc/tool.conf"] != tt.out {
+			t.Errorf("%d. %q (recorded %q) => %v, wanted: %d", i, tt.current, tt.recorded, plan, tt.out)
+		}
+	}
+}
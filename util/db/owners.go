@@ -0,0 +1,64 @@
+package db
+
+import "path"
+
+// Target returns where `file` of `r` is installed below `root`.
+func Target(root string, r Receipt, file File) string {
+	return path.Join(root, r.Destination, file.Path)
+}
+
+// Owned returns the paths below `root` owned by `receipts`, except for those
+// of the packages in `except`.
+func Owned(root string, receipts []Receipt, except map[string]bool) map[string]bool {
+	owned := make(map[string]bool)
+
+	for _, r := range receipts {
+		if except[r.Name] {
+			continue
+		}
+
+		for _, file := range r.Files {
+			owned[Target(root, r, file)] = true
+		}
+	}
+
+	return owned
+}
+
+// Owners returns the packages of `receipts` which own `p`, which may be given
+// as an absolute path or relative to `root`.
+func Owners(root string, receipts []Receipt, p string) []string {
+	owners := make([]string, 0)
+
+	for _, r := range receipts {
+		for _, file := range r.Files {
+			if target := Target(root, r, file); target == path.Clean(p) || target == path.Join(root, p) {
+				owners = append(owners, r.Name)
+				break
+			}
+		}
+	}
+
+	return owners
+}
+
+// Dependents returns the packages of `receipts` which depend on `name`,
+// except for those in `except` (i.e. the packages removed along with it).
+func Dependents(receipts []Receipt, name string, except map[string]bool) []string {
+	dependents := make([]string, 0)
+
+	for _, r := range receipts {
+		if except[r.Name] {
+			continue
+		}
+
+		for _, dep := range r.Dependencies {
+			if dep == name {
+				dependents = append(dependents, r.Name)
+				break
+			}
+		}
+	}
+
+	return dependents
+}
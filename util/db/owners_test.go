@@ -0,0 +1,93 @@
+package db_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/db"
+)
+
+var ownersReceipts = []db.Receipt{
+	{Name: "tool", Destination: "/", Files: []db.File{
+		{Path: "/usr/bin", Type: db.TYPE_DIR},
+		{Path: "/usr/bin/tool", Type: db.TYPE_FILE},
+	}},
+	{Name: "lib", Destination: "/usr/local", Files: []db.File{
+		{Path: "/lib/libtool.so", Type: db.TYPE_FILE},
+	}, Dependencies: []string{"tool"}},
+	{Name: "plugin", Destination: "/", Dependencies: []string{"lib", "tool"}},
+}
+
+func TestOwned(t *testing.T) {
+	var tests = []struct {
+		root   string
+		except map[string]bool
+		owned  []string
+	}{
+		{"/", nil, []string{"/usr/bin", "/usr/bin/tool", "/usr/local/lib/libtool.so"}},
+		{"/mnt/image", nil, []string{"/mnt/image/usr/bin", "/mnt/image/usr/bin/tool", "/mnt/image/usr/local/lib/libtool.so"}},
+		{"/", map[string]bool{"tool": true}, []string{"/usr/local/lib/libtool.so"}},
+		{"/", map[string]bool{"tool": true, "lib": true}, []string{}},
+	}
+
+	for i, tt := range tests {
+		owned := db.Owned(tt.root, ownersReceipts, tt.except)
+
+		expected := make(map[string]bool)
+		for _, p := range tt.owned {
+			expected[p] = true
+		}
+		if !reflect.DeepEqual(owned, expected) {
+			t.Errorf("%d. Owned(%q, %v) => %v, wanted: %v", i, tt.root, tt.except, owned, expected)
+		}
+	}
+}
+
+func TestDependents(t *testing.T) {
+	var tests = []struct {
+		name       string
+		except     map[string]bool
+		dependents []string
+	}{
+		{"tool", nil, []string{"lib", "plugin"}},
+		{"tool", map[string]bool{"plugin": true}, []string{"lib"}},
+		{"lib", nil, []string{"plugin"}},
+		{"plugin", nil, []string{}},
+	}
+
+	for i, tt := range tests {
+		dependents := db.Dependents(ownersReceipts, tt.name, tt.except)
+		if !reflect.DeepEqual(dependents, tt.dependents) {
+			t.Errorf("%d. Dependents(%q, %v) => %v, wanted: %v", i, tt.name, tt.except, dependents, tt.dependents)
+		}
+	}
+}
+
+func TestOwners(t *testing.T) {
+	receipts := append(ownersReceipts, db.Receipt{Name: "tool-doc", Destination: "/", Files: []db.File{
+		{Path: "/usr/bin", Type: db.TYPE_DIR},
+	}})
+
+	var tests = []struct {
+		root   string
+		path   string
+		owners []string
+	}{
+		{"/", "/usr/bin/tool", []string{"tool"}},
+		{"/", "/usr/bin/tool/", []string{"tool"}},
+		{"/", "/usr/bin", []string{"tool", "tool-doc"}},
+		{"/", "/usr/local/lib/libtool.so", []string{"lib"}},
+		{"/", "/lib/libtool.so", []string{}},
+		{"/mnt/image", "/usr/bin/tool", []string{"tool"}},
+		{"/mnt/image", "/mnt/image/usr/bin/tool", []string{"tool"}},
+		{"/mnt/image", "usr/local/lib/libtool.so", []string{"lib"}},
+		{"/mnt/image", "/etc/passwd", []string{}},
+	}
+
+	for i, tt := range tests {
+		owners := db.Owners(tt.root, receipts, tt.path)
+		if !reflect.DeepEqual(owners, tt.owners) {
+			t.Errorf("%d. Owners(%q, %q) => %v, wanted: %v", i, tt.root, tt.path, owners, tt.owners)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PACKAGES = "packages" // Directory in the database with a receipt per package
+
+	TYPE_FILE = "file"
+	TYPE_DIR  = "dir"
+	TYPE_LINK = "link"
+)
+
+// Receipt records an installed package and every path it installed.
+type Receipt struct {
+	Name         string    `yaml:"name" json:"name"`
+	Version      string    `yaml:"version" json:"version"`
+	Revision     string    `yaml:"revision,omitempty" json:"revision,omitempty"`
+	Maintainer   string    `yaml:"maintainer,omitempty" json:"maintainer,omitempty"`
+	Email        string    `yaml:"email,omitempty" json:"email,omitempty"`
+	Homepage     string    `yaml:"homepage,omitempty" json:"homepage,omitempty"`
+	Source       string    `yaml:"source" json:"source"`
+	Commit       string    `yaml:"commit,omitempty" json:"commit,omitempty"`
+	Signers      []string  `yaml:"signers,omitempty" json:"signers,omitempty"`
+	Dependencies []string  `yaml:"dependencies,omitempty" json:"dependencies,omitempty"`
+	Replaces     []string  `yaml:"replaces,omitempty" json:"replaces,omitempty"`
+	Destination  string    `yaml:"destination" json:"destination"` // Relative to the root the database is in
+	Installed    time.Time `yaml:"installed" json:"installed"`
+	Files        []File    `yaml:"files" json:"files"`
+}
+
+// File is a single installed path, relative to the package's destination.
+type File struct {
+	Path   string      `yaml:"path" json:"path"`
+	Type   string      `yaml:"type" json:"type"`
+	Mode   os.FileMode `yaml:"mode" json:"mode"`
+	Uid    int         `yaml:"uid" json:"uid"`
+	Gid    int         `yaml:"gid" json:"gid"`
+	Sha256 string      `yaml:"sha256,omitempty" json:"sha256,omitempty"`
+	Target string      `yaml:"target,omitempty" json:"target,omitempty"`
+	Orig   string      `yaml:"orig,omitempty" json:"orig,omitempty"` // Preserved original, restored on removal
+	Config bool        `yaml:"config,omitempty" json:"config,omitempty"`
+
+	// Created is set for directories which didn't exist before the package
+	// was installed; everything in them is expected to be owned by a package.
+	Created bool `yaml:"created,omitempty" json:"created,omitempty"`
+}
+
+// String returns the version, and revision if any, of the package.
+func (r Receipt) String() string {
+	if r.Revision != "" {
+		return fmt.Sprintf("%s rev. %s", r.Version, r.Revision)
+	}
+
+	return r.Version
+}
+
+// receiptPath returns the receipt file of package `name`, which has to be a
+// plain file name so it can't point outside of the database.
+func receiptPath(dbdir string, name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\\x00") {
+		e := fmt.Sprintf("Invalid package name %q", name)
+		return "", errors.New(e)
+	}
+
+	return path.Join(dbdir, PACKAGES, name+".yaml"), nil
+}
+
+// ReadReceipt returns the receipt for package `name`, if it's installed.
+func ReadReceipt(dbdir string, name string) (Receipt, bool, error) {
+	var r Receipt
+
+	file, err := receiptPath(dbdir, name)
+	if err != nil {
+		return r, false, err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, false, nil
+		}
+		e := fmt.Sprintf("Could not read receipt for %s: %s", name, err)
+		return r, false, errors.New(e)
+	}
+
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		e := fmt.Sprintf("Invalid receipt %s: %s", file, err)
+		return r, false, errors.New(e)
+	}
+
+	return r, true, nil
+}
+
+// Receipts returns the receipts of all installed packages, sorted by name.
+func Receipts(dbdir string) ([]Receipt, error) {
+	receipts := make([]Receipt, 0)
+
+	entries, err := ioutil.ReadDir(path.Join(dbdir, PACKAGES))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return receipts, nil
+		}
+		e := fmt.Sprintf("Could not read package database: %s", err)
+		return nil, errors.New(e)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		r, _, err := ReadReceipt(dbdir, strings.TrimSuffix(entry.Name(), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, r)
+	}
+
+	return receipts, nil
+}
+
+// WriteReceipt records `r` in the database, replacing any previous receipt
+// for the same package. The files are sorted by path.
+func WriteReceipt(dbdir string, r Receipt) error {
+	file, err := receiptPath(dbdir, r.Name)
+	if err != nil {
+		return err
+	}
+
+	r.Files = append([]File{}, r.Files...)
+	sort.Slice(r.Files, func(i, j int) bool { return r.Files[i].Path < r.Files[j].Path })
+
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(file, data, 0644)
+}
+
+// RemoveReceipt removes the receipt for package `name`. The recorded version
+// is kept, see Version.
+func RemoveReceipt(dbdir string, name string) error {
+	file, err := receiptPath(dbdir, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		e := fmt.Sprintf("Could not remove receipt for %s: %s", name, err)
+		return errors.New(e)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package db_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RedCoolBeans/crane/util/db"
+)
+
+func TestReceipts(t *testing.T) {
+	dbdir, err := ioutil.TempDir("", "crane-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbdir)
+
+	installed := time.Date(2016, 7, 12, 22, 1, 23, 0, time.UTC)
+	var tests = []db.Receipt{
+		{
+			Name:        "tool",
+			Version:     "1.0",
+			Revision:    "2",
+			Source:      "https://git.cargos.io/tool.git",
+			Commit:      "85b6309b59bb3444356ac813b5ca5469933279b0",
+			Signers:     []string{"0123456789ABCDEF0123456789ABCDEF01234567"},
+			Destination: "/",
+			Installed:   installed,
+			Files: []db.File{
+				{Path: "/usr/bin", Type: db.TYPE_DIR, Mode: 0755},
+				{Path: "/usr/bin/tl", Type: db.TYPE_LINK, Mode: 0777, Target: "tool"},
+				{Path: "/usr/bin/tool", Type: db.TYPE_FILE, Mode: 0755, Sha256: "98ea6e4f"},
+			},
+		},
+		{Name: "lib", Version: "0.1", Destination: "/opt", Installed: installed, Files: []db.File{}},
+	}
+
+	for i, tt := range tests {
+		if err := db.WriteReceipt(dbdir, tt); err != nil {
+			t.Fatal(err)
+		}
+
+		r, ok, err := db.ReadReceipt(dbdir, tt.Name)
+		if !ok || err != nil {
+			t.Errorf("%d. %q => not found: %v", i, tt.Name, err)
+		} else if !reflect.DeepEqual(r, tt) {
+			t.Errorf("%d. %q => %+v, wanted: %+v", i, tt.Name, r, tt)
+		}
+	}
+
+	if receipts, err := db.Receipts(dbdir); err != nil || len(receipts) != 2 || receipts[0].Name != "lib" {
+		t.Errorf("Receipts() => %+v (%v), wanted lib and tool", receipts, err)
+	}
+
+	if _, ok, err := db.ReadReceipt(dbdir, "absent"); ok || err != nil {
+		t.Errorf("ReadReceipt(absent) => %v, %v, wanted: false, nil", ok, err)
+	}
+}
+
+func TestRecordVersion(t *testing.T) {
+	dbdir, err := ioutil.TempDir("", "crane-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbdir)
+
+	var tests = []struct {
+		record db.Version
+		out    db.Version
+	}{
+		{db.Version{Version: "2.0rc1"}, db.Version{Version: "2.0rc1"}},
+		{db.Version{Version: "2.0"}, db.Version{Version: "2.0"}},
+		{db.Version{Version: "1.9", Revision: "3"}, db.Version{Version: "2.0"}},
+		{db.Version{Version: "2.0", Revision: "1"}, db.Version{Version: "2.0", Revision: "1"}},
+		{db.Version{Version: "2.0rc2"}, db.Version{Version: "2.0", Revision: "1"}},
+	}
+
+	for i, tt := range tests {
+		if err := db.RecordVersion(dbdir, "tool", tt.record); err != nil {
+			t.Fatal(err)
+		}
+
+		v, ok, err := db.InstalledVersion(dbdir, "tool")
+		if err != nil || !ok {
+			t.Fatalf("%d. no version recorded: %v", i, err)
+		}
+		if v != tt.out {
+			t.Errorf("%d. recording %v => %v, wanted: %v", i, tt.record, v, tt.out)
+		}
+	}
+}
+
+func TestReceiptNames(t *testing.T) {
+	dbdir, err := ioutil.TempDir("", "crane-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbdir)
+
+	var tests = []struct {
+		name string
+		ok   bool
+	}{
+		{"tool", true},
+		{"tool-1.0_beta", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{".hidden", false},
+		{"../tool", false},
+		{"lib/tool", false},
+	}
+
+	for i, tt := range tests {
+		err := db.WriteReceipt(dbdir, db.Receipt{Name: tt.name})
+		if (err == nil) != tt.ok {
+			t.Errorf("%d. writing %q => %v, wanted ok: %v", i, tt.name, err, tt.ok)
+		}
+
+		if _, _, err := db.ReadReceipt(dbdir, tt.name); (err == nil) != tt.ok {
+			t.Errorf("%d. reading %q => %v, wanted ok: %v", i, tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+// Upgrade is the difference between a package and its previous version.
+type Upgrade struct {
+	Added   int
+	Changed int
+	Removed int
+	Stale   []File // Files of the previous version which may be removed
+}
+
+// PlanUpgrade compares `receipt` with the `previous` version of the package
+// below `root`. Files which are no longer shipped are stale, unless they're
+// `owned` by another package or are directories which existed before the
+// package was installed. The preserved originals of files which are still
+// shipped are carried over to `receipt`, so they're restored on removal.
+func PlanUpgrade(root string, previous Receipt, receipt *Receipt, owned map[string]bool) Upgrade {
+	var u Upgrade
+
+	current := make(map[string]*File)
+	for i, file := range receipt.Files {
+		current[Target(root, *receipt, file)] = &receipt.Files[i]
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range previous.Files {
+		target := Target(root, previous, file)
+		seen[target] = true
+
+		c, ok := current[target]
+		if !ok {
+			u.Removed++
+			if !owned[target] && (file.Type != TYPE_DIR || file.Created) {
+				u.Stale = append(u.Stale, file)
+			}
+			continue
+		}
+
+		if c.Orig == "" && c.Type == file.Type {
+			c.Orig = file.Orig
+		}
+
+		if c.Type != file.Type || c.Mode != file.Mode || c.Sha256 != file.Sha256 || c.Target != file.Target {
+			u.Changed++
+		}
+	}
+
+	for target := range current {
+		if !seen[target] {
+			u.Added++
+		}
+	}
+
+	return u
+}
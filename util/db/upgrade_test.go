@@ -0,0 +1,73 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/db"
+)
+
+func TestPlanUpgrade(t *testing.T) {
+	previous := db.Receipt{Name: "tool", Destination: "/", Files: []db.File{
+		{Path: "/usr/bin", Type: db.TYPE_DIR},
+		{Path: "/usr/share/tool", Type: db.TYPE_DIR, Created: true},
+		{Path: "/usr/share/tool/old.txt", Type: db.TYPE_FILE, Sha256: "1"},
+		{Path: "/usr/bin/tool", Type: db.TYPE_FILE, Sha256: "2"},
+		{Path: "/usr/bin/tool-helper", Type: db.TYPE_FILE, Sha256: "3"},
+		{Path: "/etc/tool.conf", Type: db.TYPE_FILE, Sha256: "4", Orig: "/etc/tool.conf.crane-orig"},
+	}}
+
+	var tests = []struct {
+		files   []db.File
+		owned   map[string]bool
+		added   int
+		changed int
+		removed int
+		stale   []string
+	}{
+		// Unchanged
+		{previous.Files, nil, 0, 0, 0, nil},
+		// Directories which existed before are never stale
+		{[]db.File{
+			{Path: "/usr/bin/tool", Type: db.TYPE_FILE, Sha256: "5"},
+			{Path: "/usr/bin/tool-ng", Type: db.TYPE_FILE, Sha256: "6"},
+			{Path: "/etc/tool.conf", Type: db.TYPE_FILE, Sha256: "4"},
+		}, nil, 1, 1, 4, []string{"/usr/share/tool", "/usr/share/tool/old.txt", "/usr/bin/tool-helper"}},
+		// Files owned by another package are kept
+		{[]db.File{
+			{Path: "/usr/bin/tool", Type: db.TYPE_FILE, Sha256: "2"},
+			{Path: "/etc/tool.conf", Type: db.TYPE_FILE, Sha256: "4"},
+		}, map[string]bool{"/usr/bin/tool-helper": true}, 0, 0, 4, []string{"/usr/share/tool", "/usr/share/tool/old.txt"}},
+	}
+
+	for i, tt := range tests {
+		receipt := db.Receipt{Name: "tool", Destination: "/", Files: append([]db.File{}, tt.files...)}
+		u := db.PlanUpgrade("/", previous, &receipt, tt.owned)
+
+		if u.Added != tt.added || u.Changed != tt.changed || u.Removed != tt.removed {
+			t.Errorf("%d. => %d added, %d changed, %d removed, wanted: %d, %d, %d",
+				i, u.Added, u.Changed, u.Removed, tt.added, tt.changed, tt.removed)
+		}
+
+		stale := make([]string, 0)
+		for _, file := range u.Stale {
+			stale = append(stale, file.Path)
+		}
+		if len(stale) != len(tt.stale) {
+			t.Errorf("%d. stale => %v, wanted: %v", i, stale, tt.stale)
+			continue
+		}
+		for j := range stale {
+			if stale[j] != tt.stale[j] {
+				t.Errorf("%d. stale => %v, wanted: %v", i, stale, tt.stale)
+				break
+			}
+		}
+
+		// The preserved original is carried over.
+		for _, file := range receipt.Files {
+			if file.Path == "/etc/tool.conf" && file.Orig != "/etc/tool.conf.crane-orig" {
+				t.Errorf("%d. %s lost its original", i, file.Path)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/RedCoolBeans/crane/util/fs"
+	"github.com/RedCoolBeans/crane/util/hash"
+)
+
+// Discrepancy is a single difference between an installed package's receipt
+// and what's actually on disk.
+type Discrepancy struct {
+	Package  string `json:"package"`
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+const (
+	MISSING    = "missing"
+	MODIFIED   = "modified"
+	TYPE       = "type"
+	MODE       = "mode"
+	OWNER      = "owner"
+	TARGET     = "target"
+	UNEXPECTED = "unexpected"
+)
+
+// Verify compares every file recorded in `receipt` with the one on disk below
+// `root`, and looks for files in directories created by the package which
+// aren't `owned` by any package. The database in `dbdir` is never reported.
+func Verify(root string, dbdir string, receipt Receipt, owned map[string]bool) []Discrepancy {
+	discrepancies := make([]Discrepancy, 0)
+	report := func(target string, kind string, expected string, actual string) {
+		discrepancies = append(discrepancies, Discrepancy{receipt.Name, target, kind, expected, actual})
+	}
+
+	for _, file := range receipt.Files {
+		target := Target(root, receipt, file)
+
+		// A directory may be a symlink to one, i.e. with a merged /usr.
+		info, err := os.Lstat(target)
+		if err == nil && file.Type == TYPE_DIR {
+			info, err = os.Stat(target)
+		}
+		if err != nil {
+			report(target, MISSING, "", "")
+			continue
+		}
+
+		if t := fileType(info); t != file.Type {
+			report(target, TYPE, file.Type, t)
+			continue
+		}
+
+		if file.Type != TYPE_LINK && info.Mode().Perm() != file.Mode {
+			report(target, MODE, fmt.Sprintf("%#o", file.Mode), fmt.Sprintf("%#o", info.Mode().Perm()))
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && (int(stat.Uid) != file.Uid || int(stat.Gid) != file.Gid) {
+			report(target, OWNER, fmt.Sprintf("%d:%d", file.Uid, file.Gid), fmt.Sprintf("%d:%d", stat.Uid, stat.Gid))
+		}
+
+		switch file.Type {
+		case TYPE_FILE:
+			if sum, err := hash.FileSha256(target); err != nil {
+				report(target, MODIFIED, file.Sha256, err.Error())
+			} else if s := fmt.Sprintf("%x", sum); file.Sha256 != "" && s != file.Sha256 && !file.Config {
+				report(target, MODIFIED, file.Sha256, s)
+			}
+		case TYPE_LINK:
+			if link, _ := os.Readlink(target); link != file.Target {
+				report(target, TARGET, file.Target, link)
+			}
+		case TYPE_DIR:
+			if file.Created {
+				for _, unexpected := range unownedEntries(target, dbdir, owned) {
+					report(unexpected, UNEXPECTED, "", "")
+				}
+			}
+		}
+	}
+
+	return discrepancies
+}
+
+// unownedEntries returns the entries of `dir` which aren't owned by any
+// package, ignoring Crane's own staging and database files.
+func unownedEntries(dir string, dbdir string, owned map[string]bool) []string {
+	unowned := make([]string, 0)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return unowned
+	}
+
+	for _, entry := range entries {
+		target := path.Join(dir, entry.Name())
+		if owned[target] || target == dbdir || strings.HasPrefix(dbdir, target+"/") ||
+			strings.HasPrefix(entry.Name(), fs.STAGING_PREFIX) || strings.HasPrefix(entry.Name(), fs.BACKUP_PREFIX) {
+			continue
+		}
+		unowned = append(unowned, target)
+	}
+
+	return unowned
+}
+
+func fileType(info os.FileInfo) string {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return TYPE_LINK
+	case info.IsDir():
+		return TYPE_DIR
+	default:
+		return TYPE_FILE
+	}
+}
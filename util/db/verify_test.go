@@ -0,0 +1,86 @@
+package db_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/db"
+)
+
+func TestVerify(t *testing.T) {
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("tool")))
+	uid, gid := os.Getuid(), os.Getgid()
+
+	var tests = []struct {
+		change   func(root string) // modification after installing
+		kind     string            // expected discrepancy, if any
+		path     string
+		expected string
+		actual   string
+	}{
+		{func(root string) {}, "", "", "", ""},
+		{func(root string) { os.Remove(path.Join(root, "opt/tool/bin/tool")) },
+			db.MISSING, "opt/tool/bin/tool", "", ""},
+		{func(root string) { ioutil.WriteFile(path.Join(root, "opt/tool/bin/tool"), []byte("evil"), 0755) },
+			db.MODIFIED, "opt/tool/bin/tool", sum, fmt.Sprintf("%x", sha256.Sum256([]byte("evil")))},
+		{func(root string) { ioutil.WriteFile(path.Join(root, "opt/tool/etc/tool.conf"), []byte("local"), 0644) },
+			"", "", "", ""},
+		{func(root string) { os.Chmod(path.Join(root, "opt/tool/bin/tool"), 0777) },
+			db.MODE, "opt/tool/bin/tool", "0755", "0777"},
+		{func(root string) { os.Lchown(path.Join(root, "opt/tool/bin/tool"), uid+1, gid) },
+			db.OWNER, "opt/tool/bin/tool", fmt.Sprintf("%d:%d", uid, gid), fmt.Sprintf("%d:%d", uid+1, gid)},
+		{func(root string) {
+			os.Remove(path.Join(root, "opt/tool/bin/tool-link"))
+			os.Symlink("other", path.Join(root, "opt/tool/bin/tool-link"))
+		}, db.TARGET, "opt/tool/bin/tool-link", "tool", "other"},
+		{func(root string) {
+			os.Remove(path.Join(root, "opt/tool/bin/tool-link"))
+			ioutil.WriteFile(path.Join(root, "opt/tool/bin/tool-link"), []byte("tool"), 0755)
+		}, db.TYPE, "opt/tool/bin/tool-link", db.TYPE_LINK, db.TYPE_FILE},
+		{func(root string) { ioutil.WriteFile(path.Join(root, "opt/tool/bin/dropped"), []byte("?"), 0755) },
+			db.UNEXPECTED, "opt/tool/bin/dropped", "", ""},
+		{func(root string) { os.MkdirAll(path.Join(root, "opt/tool/bin/.crane-staging-1"), 0755) },
+			"", "", "", ""},
+	}
+
+	for i, tt := range tests {
+		root, err := ioutil.TempDir("", "crane-verify")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+
+		os.MkdirAll(path.Join(root, "opt/tool/bin"), 0755)
+		os.MkdirAll(path.Join(root, "opt/tool/etc"), 0755)
+		ioutil.WriteFile(path.Join(root, "opt/tool/bin/tool"), []byte("tool"), 0755)
+		ioutil.WriteFile(path.Join(root, "opt/tool/etc/tool.conf"), []byte("tool"), 0644)
+		os.Symlink("tool", path.Join(root, "opt/tool/bin/tool-link"))
+
+		receipt := db.Receipt{Name: "tool", Destination: "/opt/tool", Files: []db.File{
+			{Path: "/bin", Type: db.TYPE_DIR, Mode: 0755, Uid: uid, Gid: gid, Created: true},
+			{Path: "/bin/tool", Type: db.TYPE_FILE, Mode: 0755, Uid: uid, Gid: gid, Sha256: sum},
+			{Path: "/bin/tool-link", Type: db.TYPE_LINK, Uid: uid, Gid: gid, Target: "tool"},
+			{Path: "/etc/tool.conf", Type: db.TYPE_FILE, Mode: 0644, Uid: uid, Gid: gid, Sha256: sum, Config: true},
+		}}
+		owned := db.Owned(root, []db.Receipt{receipt}, nil)
+
+		tt.change(root)
+		discrepancies := db.Verify(root, path.Join(root, "var/db/crane"), receipt, owned)
+
+		if tt.kind == "" {
+			if len(discrepancies) > 0 {
+				t.Errorf("%d. => %v, wanted none", i, discrepancies)
+			}
+			continue
+		}
+
+		expected := db.Discrepancy{Package: "tool", Path: path.Join(root, tt.path), Kind: tt.kind, Expected: tt.expected, Actual: tt.actual}
+		if len(discrepancies) != 1 || discrepancies[0] != expected {
+			t.Errorf("%d. => %v, wanted: %v", i, discrepancies, expected)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	m "github.com/RedCoolBeans/crane/util/manifest"
+	"gopkg.in/yaml.v2"
+)
+
+const VERSIONS = "versions.yaml"
+
+// Version is the highest version and revision ever installed of a package.
+// It's kept even when the package is removed, so older releases can't be
+// installed without explicit consent.
+type Version struct {
+	Version  string `yaml:"version"`
+	Revision string `yaml:"revision,omitempty"`
+}
+
+// ReadVersions returns the recorded versions in `dbdir`, keyed by package.
+func ReadVersions(dbdir string) (map[string]Version, error) {
+	versions := make(map[string]Version)
+
+	data, err := ioutil.ReadFile(path.Join(dbdir, VERSIONS))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, nil
+		}
+		e := fmt.Sprintf("Could not read installed versions: %s", err)
+		return nil, errors.New(e)
+	}
+
+	if err := yaml.Unmarshal(data, &versions); err != nil {
+		e := fmt.Sprintf("Invalid installed versions %s: %s", path.Join(dbdir, VERSIONS), err)
+		return nil, errors.New(e)
+	}
+
+	return versions, nil
+}
+
+// InstalledVersion returns the highest recorded version of `name`.
+func InstalledVersion(dbdir string, name string) (Version, bool, error) {
+	versions, err := ReadVersions(dbdir)
+	if err != nil {
+		return Version{}, false, err
+	}
+
+	v, ok := versions[name]
+	return v, ok, nil
+}
+
+// RecordVersion records `v` as the version of `name`, unless a newer
+// version was recorded before.
+func RecordVersion(dbdir string, name string, v Version) error {
+	versions, err := ReadVersions(dbdir)
+	if err != nil {
+		return err
+	}
+
+	if !UpdateVersion(versions, name, v) {
+		return nil
+	}
+
+	return WriteVersions(dbdir, versions)
+}
+
+// UpdateVersion sets `v` as the version of `name` in `versions`, unless a
+// newer version is set already. It returns whether `versions` was changed.
+func UpdateVersion(versions map[string]Version, name string, v Version) bool {
+	if old, ok := versions[name]; ok && m.CompareVersionRevision(v.Version, v.Revision, old.Version, old.Revision) <= 0 {
+		return false
+	}
+	versions[name] = v
+
+	return true
+}
+
+// WriteVersions replaces the recorded versions in `dbdir` with `versions`.
+func WriteVersions(dbdir string, versions map[string]Version) error {
+	data, err := yaml.Marshal(versions)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(path.Join(dbdir, VERSIONS), data, 0644)
+}
+
+// WriteFileAtomic writes `data` to a temporary file next to `file` and then
+// renames it into place, so readers never see a partially written file.
+func WriteFileAtomic(file string, data []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(path.Dir(file), "."+path.Base(file)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
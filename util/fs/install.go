@@ -2,6 +2,7 @@ package fs
 
 import (
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 
@@ -55,3 +56,47 @@ func CopyFile(source string, dest string) (err error) {
 
 	return
 }
+
+// CopyFileVerified copies `source` into a temporary file next to `dest`,
+// writing everything it reads to `w` as well (i.e. a hash.Verifier). Once the
+// copy is complete `verify` is called, and only if that succeeds is the
+// temporary file renamed to `dest`, with `mode`. This way every byte is read
+// just once, and the file that's verified is exactly the file that's installed.
+func CopyFileVerified(source string, dest string, mode os.FileMode, w io.Writer, verify func() error) (err error) {
+	sourcefile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+
+	defer sourcefile.Close()
+
+	tempfile, err := ioutil.TempFile(path.Dir(dest), ".crane-")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(tempfile.Name())
+		}
+	}()
+
+	_, err = io.Copy(io.MultiWriter(tempfile, w), sourcefile)
+	if cerr := tempfile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// TempFile() creates files with 0600.
+	if err = os.Chmod(tempfile.Name(), mode); err != nil {
+		return err
+	}
+
+	if err = verify(); err != nil {
+		return err
+	}
+
+	return os.Rename(tempfile.Name(), dest)
+}
@@ -0,0 +1,74 @@
+package fs_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/fs"
+)
+
+func TestCopyFileVerified(t *testing.T) {
+	var tests = []struct {
+		existing string // contents of dest before copying, if any
+		digest   string // what verify expects to have been written
+		mode     os.FileMode
+		ok       bool
+		dest     string // expected contents of dest afterwards, if any
+	}{
+		{"", "new", 0755, true, "new"},
+		{"old", "new", 0600, true, "new"},
+		{"", "other", 0644, false, ""},
+		{"old", "other", 0644, false, "old"},
+	}
+
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "crane-install")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		source := path.Join(dir, "source")
+		ioutil.WriteFile(source, []byte("new"), 0644)
+
+		os.Mkdir(path.Join(dir, "dest"), 0755)
+		dest := path.Join(dir, "dest/file")
+		if tt.existing != "" {
+			ioutil.WriteFile(dest, []byte(tt.existing), 0644)
+		}
+
+		var written bytes.Buffer
+		err = fs.CopyFileVerified(source, dest, tt.mode, &written, func() error {
+			if written.String() != tt.digest {
+				return errors.New("digest mismatch")
+			}
+			return nil
+		})
+		if (err == nil) != tt.ok {
+			t.Errorf("%d. => %v, wanted ok: %v", i, err, tt.ok)
+		}
+
+		data, err := ioutil.ReadFile(dest)
+		if tt.dest == "" && err == nil {
+			t.Errorf("%d. %s was installed", i, dest)
+		} else if tt.dest != "" && string(data) != tt.dest {
+			t.Errorf("%d. %s => %q, expected %q", i, dest, data, tt.dest)
+		}
+
+		if info, err := os.Stat(dest); tt.ok && (err != nil || info.Mode().Perm() != tt.mode) {
+			t.Errorf("%d. %s => %v, expected mode %v", i, dest, info, tt.mode)
+		}
+
+		// Only the destination itself may be left behind.
+		entries, _ := ioutil.ReadDir(path.Join(dir, "dest"))
+		for _, entry := range entries {
+			if entry.Name() != "file" {
+				t.Errorf("%d. %s was left behind", i, entry.Name())
+			}
+		}
+	}
+}
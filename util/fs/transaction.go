@@ -0,0 +1,417 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	STAGING_PREFIX = ".crane-staging-"
+	BACKUP_PREFIX  = ".crane-backup-"
+	ORIG_SUFFIX    = ".crane-orig"
+)
+
+const (
+	staging = iota
+	committed
+	finished
+	rolledback
+)
+
+// Transaction stages everything that is to be installed into a directory on
+// the same filesystem as the destination, so it can be moved into place with
+// renames only after all packages have been verified. Any files which are
+// replaced while committing are kept aside until the transaction is finished,
+// so a rollback leaves the destination as it was.
+type Transaction struct {
+	mu    sync.Mutex
+	state int
+
+	destinations []string
+	staging      map[string]string // destination -> staging directory
+	backup       map[string]string // destination -> backup directory
+	modes        map[string]os.FileMode
+	owners       map[string]owner
+	removals     map[string][]removal // destination -> paths to remove
+	preserve     map[string]bool      // targets whose original is kept as ORIG_SUFFIX
+	created      []string             // destination directories created by Stage
+
+	journal []change
+}
+
+// owner is the uid and gid of a path; -1 leaves either unchanged.
+type owner struct {
+	uid int
+	gid int
+}
+
+// removal is a path to remove, and optionally the original to restore in its place.
+type removal struct {
+	src  string
+	orig string
+}
+
+// change records a single modification to a destination while committing.
+type change struct {
+	target  string
+	backup  string      // where a replaced file was moved to, if any
+	created bool        // whether target is a directory we created
+	removed bool        // whether target is a directory we removed
+	moved   bool        // whether backup was moved to target
+	mode    os.FileMode // previous mode of an existing directory, if changed
+	owner   *owner      // previous owner of an existing directory, if changed
+}
+
+func NewTransaction() *Transaction {
+	return &Transaction{
+		staging:  make(map[string]string),
+		backup:   make(map[string]string),
+		modes:    make(map[string]os.FileMode),
+		owners:   make(map[string]owner),
+		removals: make(map[string][]removal),
+		preserve: make(map[string]bool),
+	}
+}
+
+// Stage returns the staging directory for `destination`, creating it on first use.
+func (t *Transaction) Stage(destination string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if dir, ok := t.staging[destination]; ok {
+		return dir, nil
+	}
+
+	// Destinations which don't exist yet are removed again on rollback.
+	missing := make([]string, 0)
+	for dir := path.Clean(destination); ; dir = path.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil || dir == path.Dir(dir) {
+			break
+		}
+		missing = append(missing, dir)
+	}
+
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		e := fmt.Sprintf("Could not create destination %s: %s", destination, err)
+		return "", errors.New(e)
+	}
+	t.created = append(t.created, missing...)
+
+	dir, err := ioutil.TempDir(destination, STAGING_PREFIX)
+	if err != nil {
+		e := fmt.Sprintf("Could not create staging directory in %s: %s", destination, err)
+		return "", errors.New(e)
+	}
+
+	backup, err := ioutil.TempDir(destination, BACKUP_PREFIX)
+	if err != nil {
+		os.RemoveAll(dir)
+		e := fmt.Sprintf("Could not create backup directory in %s: %s", destination, err)
+		return "", errors.New(e)
+	}
+
+	t.destinations = append(t.destinations, destination)
+	t.staging[destination] = dir
+	t.backup[destination] = backup
+
+	return dir, nil
+}
+
+// Chmod sets the mode of the `staged` path. For directories the mode is
+// also applied to an already existing directory when committing; otherwise
+// existing directories keep their mode.
+func (t *Transaction) Chmod(staged string, mode os.FileMode) error {
+	if err := os.Chmod(staged, mode); err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(staged); err == nil && info.IsDir() {
+		t.mu.Lock()
+		t.modes[staged] = mode
+		t.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Chown sets the owner of the `staged` path, like Chmod does the mode.
+func (t *Transaction) Chown(staged string, uid int, gid int) error {
+	if err := os.Lchown(staged, uid, gid); err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(staged); err == nil && info.IsDir() {
+		t.mu.Lock()
+		t.owners[staged] = owner{uid, gid}
+		t.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Changes reports whether the mode and owner of the directory `staged` are
+// applied to an existing directory when committing, see Chmod and Chown.
+func (t *Transaction) Changes(staged string) (mode bool, owner bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, mode = t.modes[staged]
+	_, owner = t.owners[staged]
+	return mode, owner
+}
+
+// Remove schedules `src` to be removed from `destination` when committing,
+// after all staged files have been moved into place. Directories are only
+// removed if they're empty by then.
+func (t *Transaction) Remove(destination string, src string) error {
+	if _, err := t.Stage(destination); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.removals[destination] = append(t.removals[destination], removal{src: src})
+	t.mu.Unlock()
+
+	return nil
+}
+
+// Restore is like Remove, but moves `orig` (i.e. a preserved original) into
+// the place of `src` afterwards.
+func (t *Transaction) Restore(destination string, src string, orig string) error {
+	if _, err := t.Stage(destination); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.removals[destination] = append(t.removals[destination], removal{src: src, orig: orig})
+	t.mu.Unlock()
+
+	return nil
+}
+
+// Preserve keeps the file currently at `target`, if any, as target+ORIG_SUFFIX
+// when it's replaced while committing, instead of discarding it.
+func (t *Transaction) Preserve(target string) {
+	t.mu.Lock()
+	t.preserve[target] = true
+	t.mu.Unlock()
+}
+
+// Commit moves all staged files into their destinations. If it fails, the
+// caller is expected to Rollback().
+func (t *Transaction) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != staging {
+		return errors.New("Transaction was already committed or rolled back")
+	}
+	t.state = committed
+
+	for _, destination := range t.destinations {
+		if err := t.commit(destination); err != nil {
+			return err
+		}
+	}
+
+	for _, destination := range t.destinations {
+		if err := t.remove(destination); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *Transaction) commit(destination string) error {
+	stagingdir := t.staging[destination]
+	backups := 0
+
+	return filepath.Walk(stagingdir, func(staged string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if staged == stagingdir {
+			return nil
+		}
+
+		target := path.Join(destination, strings.TrimPrefix(staged, stagingdir))
+		existing, lerr := os.Lstat(target)
+
+		if info.IsDir() {
+			if lerr != nil {
+				if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+					return err
+				}
+				t.journal = append(t.journal, change{target: target, created: true})
+				if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+					if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+						return err
+					}
+				}
+				return os.Chmod(target, info.Mode().Perm())
+			}
+
+			// A symlink to a directory (i.e. /bin -> usr/bin) is used as is,
+			// the directory it points to isn't ours to change.
+			if existing.Mode()&os.ModeSymlink != 0 {
+				if resolved, err := os.Stat(target); err == nil && resolved.IsDir() {
+					return nil
+				}
+			}
+
+			if !existing.IsDir() {
+				e := fmt.Sprintf("Could not install directory %s: a file is in the way", target)
+				return errors.New(e)
+			}
+
+			if mode, ok := t.modes[staged]; ok && mode != existing.Mode().Perm() {
+				t.journal = append(t.journal, change{target: target, mode: existing.Mode().Perm()})
+				if err := os.Chmod(target, mode); err != nil {
+					return err
+				}
+			}
+
+			if o, ok := t.owners[staged]; ok {
+				if stat, ok := existing.Sys().(*syscall.Stat_t); ok {
+					t.journal = append(t.journal, change{target: target, owner: &owner{int(stat.Uid), int(stat.Gid)}})
+					return os.Lchown(target, o.uid, o.gid)
+				}
+			}
+
+			return nil
+		}
+
+		c := change{target: target}
+		if lerr == nil {
+			if existing.IsDir() {
+				e := fmt.Sprintf("Could not install %s: a directory is in the way", target)
+				return errors.New(e)
+			}
+
+			backups++
+			c.backup = path.Join(t.backup[destination], fmt.Sprint(backups))
+			if t.preserve[target] {
+				c.backup = target + ORIG_SUFFIX
+			}
+			if err := os.Rename(target, c.backup); err != nil {
+				return err
+			}
+		}
+
+		// Journal before renaming, so a replaced file is restored even if
+		// the rename itself fails.
+		t.journal = append(t.journal, c)
+		return os.Rename(staged, target)
+	})
+}
+
+func (t *Transaction) remove(destination string) error {
+	removals := t.removals[destination]
+
+	// Remove the deepest paths first, so directories may become empty.
+	sort.Slice(removals, func(i, j int) bool { return removals[i].src > removals[j].src })
+
+	for i, r := range removals {
+		target := path.Join(destination, r.src)
+
+		info, err := os.Lstat(target)
+		if err == nil && info.IsDir() {
+			if err := os.Remove(target); err == nil {
+				t.journal = append(t.journal, change{target: target, removed: true, mode: info.Mode().Perm()})
+			}
+			continue
+		}
+
+		if err == nil {
+			c := change{target: target, backup: path.Join(t.backup[destination], fmt.Sprintf("removed-%d", i))}
+			if err := os.Rename(target, c.backup); err != nil {
+				return err
+			}
+			t.journal = append(t.journal, c)
+		}
+
+		if _, err := os.Lstat(r.orig); r.orig != "" && err == nil {
+			if err := os.Rename(r.orig, target); err != nil {
+				return err
+			}
+			t.journal = append(t.journal, change{target: target, backup: r.orig, moved: true})
+		}
+	}
+
+	return nil
+}
+
+// Rollback undoes everything that was committed so far, restoring any files
+// that were replaced, and removes the staging directories. It's safe to call
+// at any time, i.e. from an interrupt handler, and does nothing once the
+// transaction has finished.
+func (t *Transaction) Rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state == finished || t.state == rolledback {
+		return
+	}
+	t.state = rolledback
+
+	for i := len(t.journal) - 1; i >= 0; i-- {
+		c := t.journal[i]
+		switch {
+		case c.created:
+			os.Remove(c.target)
+		case c.removed:
+			os.Mkdir(c.target, c.mode)
+			os.Chmod(c.target, c.mode)
+		case c.moved:
+			os.Rename(c.target, c.backup)
+		case c.owner != nil:
+			os.Lchown(c.target, c.owner.uid, c.owner.gid)
+		case c.mode != 0:
+			os.Chmod(c.target, c.mode)
+		default:
+			os.Remove(c.target)
+			if c.backup != "" {
+				os.Rename(c.backup, c.target)
+			}
+		}
+	}
+
+	t.cleanup()
+
+	// Remove the deepest directories first, so their parents become empty.
+	sort.Sort(sort.Reverse(sort.StringSlice(t.created)))
+	for _, dir := range t.created {
+		os.Remove(dir)
+	}
+}
+
+// Finish removes the staging directories and any replaced files, after which
+// the transaction can no longer be rolled back.
+func (t *Transaction) Finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state == finished || t.state == rolledback {
+		return
+	}
+	t.state = finished
+
+	t.cleanup()
+}
+
+func (t *Transaction) cleanup() {
+	for _, destination := range t.destinations {
+		os.RemoveAll(t.staging[destination])
+		os.RemoveAll(t.backup[destination])
+	}
+}
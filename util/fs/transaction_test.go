@@ -0,0 +1,393 @@
+package fs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/fs"
+)
+
+func TestTransaction(t *testing.T) {
+	var tests = []struct {
+		rollback bool
+		conf     string // expected contents of etc/tool.conf afterwards
+		tool     bool   // whether usr/bin/tool is expected to exist
+		etcmode  os.FileMode
+	}{
+		{false, "new", true, 0700},
+		{true, "old", false, 0755},
+	}
+
+	for i, tt := range tests {
+		dest, err := ioutil.TempDir("", "crane-tx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		os.MkdirAll(path.Join(dest, "etc"), 0755)
+		ioutil.WriteFile(path.Join(dest, "etc/tool.conf"), []byte("old"), 0644)
+
+		tx := fs.NewTransaction()
+		staging, err := tx.Stage(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.MkdirAll(path.Join(staging, "usr/bin"), 0755)
+		os.MkdirAll(path.Join(staging, "etc"), 0755)
+		tx.Chmod(path.Join(staging, "etc"), 0700)
+		ioutil.WriteFile(path.Join(staging, "usr/bin/tool"), []byte("tool"), 0755)
+		ioutil.WriteFile(path.Join(staging, "etc/tool.conf"), []byte("new"), 0644)
+
+		// Nothing may be visible before committing.
+		if _, err := os.Stat(path.Join(dest, "usr/bin/tool")); err == nil {
+			t.Errorf("%d. usr/bin/tool installed before commit", i)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.rollback {
+			tx.Rollback()
+		}
+		tx.Finish()
+
+		if data, _ := ioutil.ReadFile(path.Join(dest, "etc/tool.conf")); string(data) != tt.conf {
+			t.Errorf("%d. etc/tool.conf => %q, wanted: %q", i, data, tt.conf)
+		}
+
+		if _, err := os.Stat(path.Join(dest, "usr/bin/tool")); (err == nil) != tt.tool {
+			t.Errorf("%d. usr/bin/tool exists => %v, wanted: %v", i, err == nil, tt.tool)
+		}
+
+		if _, err := os.Stat(path.Join(dest, "usr")); !tt.tool && err == nil {
+			t.Errorf("%d. usr/ was not removed by rollback", i)
+		}
+
+		if info, _ := os.Stat(path.Join(dest, "etc")); info.Mode().Perm() != tt.etcmode {
+			t.Errorf("%d. etc/ mode => %#o, wanted: %#o", i, info.Mode().Perm(), tt.etcmode)
+		}
+
+		if files, _ := ioutil.ReadDir(dest); len(files) != 1+btoi(tt.tool) {
+			t.Errorf("%d. leftover files in destination: %d entries", i, len(files))
+		}
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func TestTransactionRemove(t *testing.T) {
+	for _, rollback := range []bool{false, true} {
+		dest, err := ioutil.TempDir("", "crane-tx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		os.MkdirAll(path.Join(dest, "usr/lib"), 0755)
+		ioutil.WriteFile(path.Join(dest, "usr/lib/obsolete"), []byte("old"), 0644)
+
+		tx := fs.NewTransaction()
+		staging, err := tx.Stage(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.MkdirAll(path.Join(staging, "usr/bin"), 0755)
+		ioutil.WriteFile(path.Join(staging, "usr/bin/tool"), []byte("tool"), 0755)
+		tx.Remove(dest, "/usr/lib/obsolete")
+		tx.Remove(dest, "/usr/lib")
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if rollback {
+			tx.Rollback()
+		}
+		tx.Finish()
+
+		var tests = []struct {
+			file   string
+			exists bool // after committing, the opposite after a rollback
+		}{
+			{"usr/bin/tool", true},
+			{"usr/lib/obsolete", false},
+			{"usr/lib", false},
+		}
+
+		for i, tt := range tests {
+			if _, err := os.Stat(path.Join(dest, tt.file)); (err == nil) != (tt.exists != rollback) {
+				t.Errorf("%d. rollback %v: %s exists => %v, wanted: %v", i, rollback, tt.file, err == nil, tt.exists != rollback)
+			}
+		}
+
+		if data, _ := ioutil.ReadFile(path.Join(dest, "usr/lib/obsolete")); rollback && string(data) != "old" {
+			t.Errorf("rollback: usr/lib/obsolete => %q, wanted: %q", data, "old")
+		}
+	}
+}
+
+func TestTransactionChown(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing owners requires root")
+	}
+
+	for _, rollback := range []bool{false, true} {
+		dest, err := ioutil.TempDir("", "crane-tx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		os.MkdirAll(path.Join(dest, "var/lib"), 0755)
+
+		tx := fs.NewTransaction()
+		staging, err := tx.Stage(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.MkdirAll(path.Join(staging, "var/lib/tool"), 0755)
+		ioutil.WriteFile(path.Join(staging, "var/lib/tool/data"), []byte("data"), 0644)
+		os.Lchown(path.Join(staging, "var/lib/tool"), 1001, 1001)
+		tx.Chown(path.Join(staging, "var/lib"), 1000, 1000)
+		tx.Chown(path.Join(staging, "var/lib/tool/data"), 1001, 1002)
+
+		if mode, owner := tx.Changes(path.Join(staging, "var/lib")); mode || !owner {
+			t.Errorf("var/lib changes => mode: %v, owner: %v, wanted: false, true", mode, owner)
+		}
+		if _, owner := tx.Changes(path.Join(staging, "var/lib/tool")); owner {
+			t.Errorf("var/lib/tool changes owner, only Lchown was used")
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if rollback {
+			tx.Rollback()
+		}
+		tx.Finish()
+
+		var tests = []struct {
+			file string
+			uid  int
+			gid  int
+		}{
+			{"var/lib", 1000, 1000},
+			{"var/lib/tool", 1001, 1001},
+			{"var/lib/tool/data", 1001, 1002},
+		}
+
+		for i, tt := range tests {
+			info, err := os.Lstat(path.Join(dest, tt.file))
+			if rollback {
+				if tt.file == "var/lib" {
+					if stat := info.Sys().(*syscall.Stat_t); stat.Uid != 0 || stat.Gid != 0 {
+						t.Errorf("%d. %s owner after rollback => %d:%d, wanted: 0:0", i, tt.file, stat.Uid, stat.Gid)
+					}
+				} else if err == nil {
+					t.Errorf("%d. %s exists after rollback", i, tt.file)
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%d. %s: %s", i, tt.file, err)
+			} else if stat := info.Sys().(*syscall.Stat_t); int(stat.Uid) != tt.uid || int(stat.Gid) != tt.gid {
+				t.Errorf("%d. %s owner => %d:%d, wanted: %d:%d", i, tt.file, stat.Uid, stat.Gid, tt.uid, tt.gid)
+			}
+		}
+	}
+}
+
+func TestTransactionSymlinkedDir(t *testing.T) {
+	for _, rollback := range []bool{false, true} {
+		dest, err := ioutil.TempDir("", "crane-tx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		// Merged /usr, bin/ is a symlink to usr/bin/.
+		os.MkdirAll(path.Join(dest, "usr/bin"), 0755)
+		os.Symlink("usr/bin", path.Join(dest, "bin"))
+
+		tx := fs.NewTransaction()
+		staging, err := tx.Stage(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.MkdirAll(path.Join(staging, "bin"), 0700)
+		ioutil.WriteFile(path.Join(staging, "bin/tool"), []byte("tool"), 0755)
+		tx.Chmod(path.Join(staging, "bin"), 0700)
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if rollback {
+			tx.Rollback()
+		}
+		tx.Finish()
+
+		if info, err := os.Lstat(path.Join(dest, "bin")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("rollback %v: bin is no longer a symlink", rollback)
+		}
+
+		if info, _ := os.Stat(path.Join(dest, "usr/bin")); info.Mode().Perm() != 0755 {
+			t.Errorf("rollback %v: usr/bin/ mode => %#o, wanted: 0755", rollback, info.Mode().Perm())
+		}
+
+		if _, err := os.Stat(path.Join(dest, "usr/bin/tool")); (err == nil) == rollback {
+			t.Errorf("rollback %v: usr/bin/tool exists => %v", rollback, err == nil)
+		}
+	}
+}
+
+func TestTransactionPreserve(t *testing.T) {
+	for _, rollback := range []bool{false, true} {
+		dest, err := ioutil.TempDir("", "crane-tx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		os.MkdirAll(path.Join(dest, "etc"), 0755)
+		ioutil.WriteFile(path.Join(dest, "etc/tool.conf"), []byte("old"), 0644)
+
+		tx := fs.NewTransaction()
+		staging, err := tx.Stage(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.MkdirAll(path.Join(staging, "etc"), 0755)
+		ioutil.WriteFile(path.Join(staging, "etc/tool.conf"), []byte("new"), 0644)
+		tx.Preserve(path.Join(dest, "etc/tool.conf"))
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if rollback {
+			tx.Rollback()
+		}
+		tx.Finish()
+
+		var tests = []struct {
+			file string
+			data string // "" if the file isn't expected to exist
+		}{
+			{"etc/tool.conf", "new"},
+			{"etc/tool.conf" + fs.ORIG_SUFFIX, "old"},
+		}
+		if rollback {
+			tests[0].data, tests[1].data = "old", ""
+		}
+
+		for i, tt := range tests {
+			if data, _ := ioutil.ReadFile(path.Join(dest, tt.file)); string(data) != tt.data {
+				t.Errorf("%d. rollback %v: %s => %q, wanted: %q", i, rollback, tt.file, data, tt.data)
+			}
+		}
+	}
+}
+
+func TestTransactionRestore(t *testing.T) {
+	for _, rollback := range []bool{false, true} {
+		dest, err := ioutil.TempDir("", "crane-tx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		os.MkdirAll(path.Join(dest, "etc"), 0755)
+		ioutil.WriteFile(path.Join(dest, "etc/tool.conf"), []byte("new"), 0644)
+		ioutil.WriteFile(path.Join(dest, "etc/tool.conf"+fs.ORIG_SUFFIX), []byte("old"), 0644)
+
+		tx := fs.NewTransaction()
+		if err := tx.Restore(dest, "/etc/tool.conf", path.Join(dest, "etc/tool.conf"+fs.ORIG_SUFFIX)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if rollback {
+			tx.Rollback()
+		}
+		tx.Finish()
+
+		var tests = []struct {
+			file string
+			data string // "" if the file isn't expected to exist
+		}{
+			{"etc/tool.conf", "old"},
+			{"etc/tool.conf" + fs.ORIG_SUFFIX, ""},
+		}
+		if rollback {
+			tests[0].data, tests[1].data = "new", "old"
+		}
+
+		for i, tt := range tests {
+			if data, _ := ioutil.ReadFile(path.Join(dest, tt.file)); string(data) != tt.data {
+				t.Errorf("%d. rollback %v: %s => %q, wanted: %q", i, rollback, tt.file, data, tt.data)
+			}
+		}
+
+		if files, _ := ioutil.ReadDir(dest); len(files) != 1 {
+			t.Errorf("rollback %v: leftover files in destination: %d entries", rollback, len(files))
+		}
+	}
+}
+
+func TestTransactionStageCreated(t *testing.T) {
+	var tests = []struct {
+		rollback bool
+		exists   bool // whether the destination is expected to exist afterwards
+	}{
+		{false, true},
+		{true, false},
+	}
+
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "crane-tx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		tx := fs.NewTransaction()
+		if _, err := tx.Stage(path.Join(dir, "opt/tool")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Stage(path.Join(dir, "opt/tool/lib")); err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.rollback {
+			tx.Rollback()
+		}
+		tx.Finish()
+
+		if _, err := os.Stat(path.Join(dir, "opt/tool/lib")); (err == nil) != tt.exists {
+			t.Errorf("%d. opt/tool/lib exists: %v, expected %v", i, err == nil, tt.exists)
+		}
+		if _, err := os.Stat(path.Join(dir, "opt")); (err == nil) != tt.exists {
+			t.Errorf("%d. opt exists: %v, expected %v", i, err == nil, tt.exists)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("%d. existing %s was removed", i, dir)
+		}
+	}
+}
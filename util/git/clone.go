@@ -4,28 +4,261 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 
 	git2go "gopkg.in/libgit2/git2go.v24"
 )
 
+// Clone clones `repository` into `tempdir` and checks out `branch`, which may
+// also name a tag. libgit2 only resolves branches when cloning, so the default
+// branch is cloned and the commit `branch` points at is checked out afterwards.
 func Clone(repository string, branch string, tempdir string, options git2go.CloneOptions) error {
-	_, err := git2go.Clone(repository, tempdir, &options)
+	options.CheckoutBranch = ""
+	repo, err := git2go.Clone(repository, tempdir, &options)
 	if err != nil {
 		e := fmt.Sprintf("Could not clone %s (%s) into %s: %s\n    Are you using a password protected SSH key without -sshpass?", repository, branch, tempdir, err)
 		return errors.New(e)
 	}
+	defer repo.Free()
+
+	return checkoutRef(repo, branch)
+}
+
+// checkoutRef checks out the commit the remote branch or tag `ref` points at,
+// with a detached HEAD.
+func checkoutRef(repo *git2go.Repository, ref string) error {
+	reference, err := repo.References.Lookup("refs/remotes/origin/" + ref)
+	if err != nil {
+		reference, err = repo.References.Lookup("refs/tags/" + ref)
+	}
+	if err != nil {
+		e := fmt.Sprintf("Could not find a branch or tag named %s", ref)
+		return errors.New(e)
+	}
+	defer reference.Free()
+
+	obj, err := reference.Peel(git2go.ObjectCommit)
+	if err != nil {
+		e := fmt.Sprintf("Could not peel %s to a commit: %s", ref, err)
+		return errors.New(e)
+	}
+	defer obj.Free()
+
+	commit, err := repo.LookupCommit(obj.Id())
+	if err != nil {
+		e := fmt.Sprintf("Could not lookup commit %s: %s", obj.Id(), err)
+		return errors.New(e)
+	}
+	defer commit.Free()
+
+	tree, err := commit.Tree()
+	if err != nil {
+		e := fmt.Sprintf("Could not lookup tree of %s: %s", obj.Id(), err)
+		return errors.New(e)
+	}
+	defer tree.Free()
+
+	if err := repo.CheckoutTree(tree, &git2go.CheckoutOpts{Strategy: git2go.CheckoutForce}); err != nil {
+		e := fmt.Sprintf("Could not checkout %s: %s", ref, err)
+		return errors.New(e)
+	}
+
+	if err := repo.SetHeadDetached(commit.Id()); err != nil {
+		e := fmt.Sprintf("Could not checkout %s: %s", ref, err)
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+// UpdateSubmodules recursively initializes and checks out all submodules of
+// the repository cloned into `tempdir`. The FetchOptions (and thus the
+// credential callbacks) from `options` are re-used for every submodule.
+func UpdateSubmodules(tempdir string, options git2go.CloneOptions) error {
+	repo, err := git2go.OpenRepository(tempdir)
+	if err != nil {
+		e := fmt.Sprintf("Could not open repository %s: %s", tempdir, err)
+		return errors.New(e)
+	}
+	defer repo.Free()
+
+	return updateSubmodules(repo, tempdir, options)
+}
+
+func updateSubmodules(repo *git2go.Repository, workdir string, options git2go.CloneOptions) error {
+	// libgit2 doesn't like modifying submodules while iterating over them,
+	// so collect the names first and update them afterwards.
+	names := make([]string, 0)
+	err := repo.Submodules.Foreach(func(sub *git2go.Submodule, name string) int {
+		names = append(names, name)
+		return 0
+	})
+	if err != nil {
+		e := fmt.Sprintf("Could not list submodules in %s: %s", workdir, err)
+		return errors.New(e)
+	}
+
+	uopts := &git2go.SubmoduleUpdateOptions{
+		CheckoutOpts:          &git2go.CheckoutOpts{Strategy: git2go.CheckoutSafe},
+		FetchOptions:          options.FetchOptions,
+		CloneCheckoutStrategy: git2go.CheckoutSafe,
+	}
+
+	for _, name := range names {
+		sub, err := repo.Submodules.Lookup(name)
+		if err != nil {
+			e := fmt.Sprintf("Could not lookup submodule %s: %s", name, err)
+			return errors.New(e)
+		}
+
+		if err := sub.Update(true, uopts); err != nil {
+			e := fmt.Sprintf("Could not update submodule %s (%s): %s", name, sub.Url(), err)
+			return errors.New(e)
+		}
+
+		subrepo, err := sub.Open()
+		if err != nil {
+			e := fmt.Sprintf("Could not open submodule %s: %s", name, err)
+			return errors.New(e)
+		}
+
+		err = updateSubmodules(subrepo, path.Join(workdir, sub.Path()), options)
+		subrepo.Free()
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// RemoveDotGit removes the .git directory from `tempdir`, as well as any
+// .git files or directories left behind by submodules.
 func RemoveDotGit(tempdir string) error {
-	// XXX: Find the right glob to exclude .git
-	tempGit := fmt.Sprintf("%s/.git", tempdir)
+	dotgits := make([]string, 0)
 
-	if err := os.RemoveAll(tempGit); err != nil {
-		e := fmt.Sprintf("Could not remove %s: %s", tempGit, err)
+	err := filepath.Walk(tempdir, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Name() == ".git" {
+			dotgits = append(dotgits, fullpath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		e := fmt.Sprintf("Could not search %s for .git: %s", tempdir, err)
 		return errors.New(e)
 	}
 
+	for _, dotgit := range dotgits {
+		if err := os.RemoveAll(dotgit); err != nil {
+			e := fmt.Sprintf("Could not remove %s: %s", dotgit, err)
+			return errors.New(e)
+		}
+	}
+
+	return nil
+}
+
+// HeadCommit returns the id of the commit checked out in `tempdir`.
+func HeadCommit(tempdir string) (string, error) {
+	repo, err := git2go.OpenRepository(tempdir)
+	if err != nil {
+		e := fmt.Sprintf("Could not open repository %s: %s", tempdir, err)
+		return "", errors.New(e)
+	}
+	defer repo.Free()
+
+	head, err := repo.Head()
+	if err != nil {
+		e := fmt.Sprintf("Could not resolve HEAD in %s: %s", tempdir, err)
+		return "", errors.New(e)
+	}
+	defer head.Free()
+
+	return head.Target().String(), nil
+}
+
+// FileModes returns the modes of the files recorded in the tree of the commit
+// checked out in `tempdir` (including its submodules), keyed by their path
+// relative to `tempdir` with a leading slash. Git only tracks the executable
+// bit, so files are either 0755 or 0644.
+func FileModes(tempdir string) (map[string]os.FileMode, error) {
+	modes := make(map[string]os.FileMode)
+	if err := fileModes(tempdir, "/", modes); err != nil {
+		return nil, err
+	}
+
+	return modes, nil
+}
+
+func fileModes(workdir string, prefix string, modes map[string]os.FileMode) error {
+	repo, err := git2go.OpenRepository(workdir)
+	if err != nil {
+		e := fmt.Sprintf("Could not open repository %s: %s", workdir, err)
+		return errors.New(e)
+	}
+	defer repo.Free()
+
+	head, err := repo.Head()
+	if err != nil {
+		e := fmt.Sprintf("Could not resolve HEAD in %s: %s", workdir, err)
+		return errors.New(e)
+	}
+	defer head.Free()
+
+	commit, err := repo.LookupCommit(head.Target())
+	if err != nil {
+		e := fmt.Sprintf("Could not lookup commit %s in %s: %s", head.Target(), workdir, err)
+		return errors.New(e)
+	}
+	defer commit.Free()
+
+	tree, err := commit.Tree()
+	if err != nil {
+		e := fmt.Sprintf("Could not lookup tree of %s in %s: %s", head.Target(), workdir, err)
+		return errors.New(e)
+	}
+	defer tree.Free()
+
+	submodules := make([]string, 0)
+	err = tree.Walk(func(root string, entry *git2go.TreeEntry) int {
+		file := path.Join(prefix, root, entry.Name)
+
+		switch entry.Filemode {
+		case git2go.FilemodeBlobExecutable:
+			modes[file] = 0755
+		case git2go.FilemodeBlob:
+			modes[file] = 0644
+		case git2go.FilemodeCommit:
+			submodules = append(submodules, path.Join(root, entry.Name))
+		}
+
+		return 0
+	})
+	if err != nil {
+		e := fmt.Sprintf("Could not walk tree of %s in %s: %s", head.Target(), workdir, err)
+		return errors.New(e)
+	}
+
+	// Submodules which weren't checked out have no files to install anyway.
+	for _, sub := range submodules {
+		subdir := path.Join(workdir, sub)
+		if _, err := os.Stat(path.Join(subdir, ".git")); err != nil {
+			continue
+		}
+
+		if err := fileModes(subdir, path.Join(prefix, sub), modes); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
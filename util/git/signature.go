@@ -0,0 +1,162 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	git2go "gopkg.in/libgit2/git2go.v24"
+)
+
+const PGP_SIGNATURE = "-----BEGIN PGP SIGNATURE-----"
+
+// SignedRef returns the signed payload and the armored OpenPGP signature of
+// the checked-out `ref` in `tempdir`. If `ref` names an annotated tag the
+// tag's signature is returned, provided it points at HEAD, otherwise that of
+// the HEAD commit.
+func SignedRef(tempdir string, ref string) ([]byte, []byte, string, error) {
+	repo, err := git2go.OpenRepository(tempdir)
+	if err != nil {
+		e := fmt.Sprintf("Could not open repository %s: %s", tempdir, err)
+		return nil, nil, "", errors.New(e)
+	}
+	defer repo.Free()
+
+	odb, err := repo.Odb()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer odb.Free()
+
+	// Prefer the annotated tag if `ref` is one.
+	if tag, err := repo.References.Lookup("refs/tags/" + ref); err == nil {
+		defer tag.Free()
+
+		obj, err := repo.Lookup(tag.Target())
+		if err != nil {
+			e := fmt.Sprintf("Could not lookup %s: %s", ref, err)
+			return nil, nil, "", errors.New(e)
+		}
+
+		annotated := obj.Type() == git2go.ObjectTag
+		obj.Free()
+
+		if annotated {
+			// A signed tag only vouches for what was checked out if it points
+			// there, not e.g. at an older commit of a branch with the same name.
+			if err := tagAtHead(repo, tag, ref); err != nil {
+				return nil, nil, "", err
+			}
+
+			raw, err := readRaw(odb, tag.Target())
+			if err != nil {
+				return nil, nil, "", err
+			}
+
+			signed, signature, err := SplitTagSignature(raw)
+			return signed, signature, "tag " + ref, err
+		}
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		e := fmt.Sprintf("Could not resolve HEAD in %s: %s", tempdir, err)
+		return nil, nil, "", errors.New(e)
+	}
+	defer head.Free()
+
+	raw, err := readRaw(odb, head.Target())
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	signed, signature, err := SplitCommitSignature(raw)
+	return signed, signature, "commit " + head.Target().String(), err
+}
+
+// tagAtHead checks that the `tag` reference points at the checked-out HEAD.
+func tagAtHead(repo *git2go.Repository, tag *git2go.Reference, ref string) error {
+	commit, err := tag.Peel(git2go.ObjectCommit)
+	if err != nil {
+		e := fmt.Sprintf("Could not peel tag %s to a commit: %s", ref, err)
+		return errors.New(e)
+	}
+	defer commit.Free()
+
+	head, err := repo.Head()
+	if err != nil {
+		e := fmt.Sprintf("Could not resolve HEAD: %s", err)
+		return errors.New(e)
+	}
+	defer head.Free()
+
+	if commit.Id().String() != head.Target().String() {
+		e := fmt.Sprintf("Tag %s points at %s, but %s is checked out", ref, commit.Id(), head.Target())
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+func readRaw(odb *git2go.Odb, oid *git2go.Oid) ([]byte, error) {
+	obj, err := odb.Read(oid)
+	if err != nil {
+		e := fmt.Sprintf("Could not read object %s: %s", oid.String(), err)
+		return nil, errors.New(e)
+	}
+	defer obj.Free()
+
+	// Data() points into memory owned by libgit2, so copy it out.
+	return append([]byte(nil), obj.Data()...), nil
+}
+
+// SplitCommitSignature separates a raw commit object into the payload that
+// was signed (the commit without its gpgsig header) and the signature.
+func SplitCommitSignature(raw []byte) ([]byte, []byte, error) {
+	var signed, signature bytes.Buffer
+
+	lines := strings.SplitAfter(string(raw), "\n")
+	inHeader := true
+	inSig := false
+
+	for _, line := range lines {
+		if inHeader && inSig {
+			// Continuation lines of a header start with a single space.
+			if strings.HasPrefix(line, " ") {
+				signature.WriteString(line[1:])
+				continue
+			}
+			inSig = false
+		}
+
+		if inHeader && strings.HasPrefix(line, "gpgsig ") {
+			signature.WriteString(strings.TrimPrefix(line, "gpgsig "))
+			inSig = true
+			continue
+		}
+
+		if line == "\n" {
+			inHeader = false
+		}
+
+		signed.WriteString(line)
+	}
+
+	if signature.Len() == 0 {
+		return nil, nil, errors.New("Commit is not signed")
+	}
+
+	return signed.Bytes(), signature.Bytes(), nil
+}
+
+// SplitTagSignature separates a raw tag object into the payload that was
+// signed and the signature appended to the tag message.
+func SplitTagSignature(raw []byte) ([]byte, []byte, error) {
+	i := bytes.Index(raw, []byte(PGP_SIGNATURE))
+	if i < 0 {
+		return nil, nil, errors.New("Tag is not signed")
+	}
+
+	return raw[:i], raw[i:], nil
+}
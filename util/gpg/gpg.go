@@ -1,22 +1,43 @@
 package gpg
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/RedCoolBeans/crane/util/logging"
+	m "github.com/RedCoolBeans/crane/util/manifest"
 	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
-const MANIFEST = "MANIFEST.yaml"
+// signedData pairs a signature with the data it should have signed.
+type signedData struct {
+	signed []byte
+	sig    []byte
+}
 
-func Pubkey(path string) *os.File {
-	pubkey, err := os.Open(path)
-	if err != nil {
-		logging.PrError("Could not open public key %s: %v", path, err)
+// Signer describes the key that made a valid signature.
+type Signer struct {
+	Identities  []string
+	Fingerprint string // Fingerprint of the (sub)key that made the signature
+	Primary     string // Fingerprint of the primary key
+}
+
+// Fingerprints returns the fingerprints of both the signing and primary key.
+func (s Signer) Fingerprints() []string {
+	if s.Fingerprint == s.Primary {
+		return []string{s.Fingerprint}
 	}
 
-	return pubkey
+	return []string{s.Fingerprint, s.Primary}
 }
 
 func Signature(path string) *os.File {
@@ -28,36 +49,261 @@ func Signature(path string) *os.File {
 	return signature
 }
 
-func Verify(pubkeyPath string, signaturePath string, clonedir string, verbose bool) (bool, []string) {
-	ids := make([]string, 0)
-
-	pubkey := Pubkey(pubkeyPath)
+// Verify checks the detached signature of the MANIFEST.yaml in `clonedir`
+// against the key(s) in `pubkeyPath`. It returns the key that made the
+// signature.
+func Verify(pubkeyPath string, signaturePath string, clonedir string, verbose bool) (bool, Signer) {
 	signature := Signature(path.Join(clonedir, signaturePath))
+	defer signature.Close()
+
+	sig, err := ioutil.ReadAll(signature)
+	if err != nil {
+		logging.PrError("Could not read signature: %v", err)
+	}
+
+	return verify(pubkeyPath, readManifest(clonedir), sig, verbose)
+}
+
+// VerifyBytes checks the armored detached `signature` over `signed`, e.g. a
+// signed git commit or tag.
+func VerifyBytes(pubkeyPath string, signed []byte, signature []byte, verbose bool) (bool, Signer) {
+	return verify(pubkeyPath, signed, signature, verbose)
+}
 
-	manifest, err := os.Open(path.Join(clonedir, MANIFEST))
+// VerifyAll checks every signature of the MANIFEST.yaml in `clonedir`, i.e.
+// the signature embedded in a clearsigned MANIFEST.yaml, all signatures in
+// `signaturePath` and those in the `signaturePath`.d/ directory. It returns
+// the distinct keys that made a valid signature, invalid signatures are
+// ignored.
+func VerifyAll(pubkeyPath string, signaturePath string, clonedir string, verbose bool) []Signer {
+	signers := make([]Signer, 0)
+	manifest := readManifest(clonedir)
+
+	keyring, err := ReadKeyring(pubkeyPath)
 	if err != nil {
-		logging.PrError("Could not open %s: %v", MANIFEST, err)
+		logging.PrError("%s", err)
 	}
 
-	keyring, err := openpgp.ReadArmoredKeyRing(pubkey)
+	sigs, err := readSignatures(path.Join(clonedir, signaturePath))
 	if err != nil {
-		logging.PrError(err.Error())
+		logging.PrError("%s", err)
+	}
+
+	all := make([]signedData, 0)
+	for _, sig := range sigs {
+		all = append(all, signedData{manifest, sig})
+	}
+
+	if IsClearsigned(manifest) {
+		signed, sig, err := Clearsigned(manifest)
+		if err != nil {
+			logging.PrError("Invalid clearsigned %s: %s", m.MANIFEST, err)
+		}
+		all = append(all, signedData{signed, sig})
+	}
+
+	if len(all) == 0 {
+		logging.PrError("No signatures found for %s in %s or %s", m.MANIFEST,
+			signaturePath, signaturePath+m.SIG_DIRSUFFIX)
+	}
+
+	for _, sd := range all {
+		signer, err := check(keyring, sd.signed, sd.sig)
+		if err != nil {
+			logging.PrVerbose(verbose, "Ignoring invalid signature: %s", err)
+			continue
+		}
+
+		duplicate := false
+		for _, s := range signers {
+			if s.Primary == signer.Primary {
+				duplicate = true
+			}
+		}
+
+		if !duplicate {
+			signers = append(signers, signer)
+		}
+	}
+
+	return signers
+}
+
+// IsClearsigned reports whether `data` is a clearsigned message.
+func IsClearsigned(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(m.CLEARSIGNED))
+}
+
+// Clearsigned returns the signed bytes and the (binary) signature of the
+// clearsigned message `data`.
+func Clearsigned(data []byte) ([]byte, []byte, error) {
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no clearsigned message found")
+	}
+
+	sig, err := ioutil.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return block.Bytes, sig, nil
+}
+
+func verify(pubkeyPath string, signed []byte, sig []byte, verbose bool) (bool, Signer) {
+	keyring, err := ReadKeyring(pubkeyPath)
+	if err != nil {
+		logging.PrError("%s", err)
 	}
 
 	// Unless we're in debug mode, we don't care about the specifics of why the
 	// signature didn't check out. Yes/no is all that matters then.
-	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, manifest, signature)
+	signer, err := check(keyring, signed, sig)
 	if err != nil {
 		if verbose {
-			logging.PrError(err.Error())
+			logging.PrError("%s", err)
+		}
+		return false, signer
+	}
+
+	return true, signer
+}
+
+// check verifies a single armored or binary signature `sig` over `signed`.
+func check(keyring *Keyring, signed []byte, sig []byte) (Signer, error) {
+	signer := Signer{Identities: make([]string, 0)}
+	keyring.Rejected = nil
+
+	var entity *openpgp.Entity
+	var err error
+	if IsArmored(sig) {
+		entity, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(sig))
+	} else {
+		entity, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(sig))
+	}
+	if err != nil {
+		if len(keyring.Rejected) > 0 {
+			e := fmt.Sprintf("%s (rejected keys: %s)", err, strings.Join(keyring.Rejected, ", "))
+			return signer, errors.New(e)
+		}
+		return signer, err
+	}
+
+	for id := range entity.Identities {
+		signer.Identities = append(signer.Identities, id)
+	}
+
+	issuer, err := Issuer(sig)
+	if err != nil {
+		return signer, err
+	}
+
+	signer.Fingerprint = Fingerprint(SigningKey(entity, issuer))
+	signer.Primary = Fingerprint(entity.PrimaryKey)
+
+	return signer, nil
+}
+
+func readManifest(clonedir string) []byte {
+	manifest, err := ioutil.ReadFile(path.Join(clonedir, m.MANIFEST))
+	if err != nil {
+		logging.PrError("Could not open %s: %v", m.MANIFEST, err)
+	}
+
+	return manifest
+}
+
+// readSignatures returns all signatures found in `sigpath` (which may hold
+// multiple concatenated armored signatures) and in `sigpath`.d/*. If
+// `sigpath` ends in .sig and doesn't exist, the binary .gpg variant is tried.
+func readSignatures(sigpath string) ([][]byte, error) {
+	files := make([]string, 0)
+
+	if _, err := os.Stat(sigpath); err == nil {
+		files = append(files, sigpath)
+	} else if strings.HasSuffix(sigpath, ".sig") {
+		gpgpath := strings.TrimSuffix(sigpath, ".sig") + ".gpg"
+		if _, err := os.Stat(gpgpath); err == nil {
+			files = append(files, gpgpath)
+		}
+	}
+
+	if entries, err := ioutil.ReadDir(sigpath + m.SIG_DIRSUFFIX); err == nil {
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				files = append(files, path.Join(sigpath+m.SIG_DIRSUFFIX, entry.Name()))
+			}
+		}
+	}
+
+	sigs := make([][]byte, 0)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			e := fmt.Sprintf("Could not open signature file %s: %v", file, err)
+			return nil, errors.New(e)
+		}
+
+		if IsArmored(data) {
+			sigs = append(sigs, SplitArmored(data)...)
 		} else {
-			return false, ids
+			sigs = append(sigs, data)
 		}
 	}
 
-	for id := range signer.Identities {
-		ids = append(ids, id)
+	return sigs, nil
+}
+
+// SplitArmored splits concatenated armored signatures into separate ones.
+func SplitArmored(data []byte) [][]byte {
+	const begin = "-----BEGIN PGP SIGNATURE-----"
+
+	sigs := make([][]byte, 0)
+	for {
+		start := bytes.Index(data, []byte(begin))
+		if start < 0 {
+			break
+		}
+
+		end := bytes.Index(data[start+len(begin):], []byte(begin))
+		if end < 0 {
+			sigs = append(sigs, data[start:])
+			break
+		}
+
+		end += start + len(begin)
+		sigs = append(sigs, data[start:end])
+		data = data[end:]
+	}
+
+	return sigs
+}
+
+// Issuer returns the key id of the issuer of the armored or binary `signature`.
+func Issuer(signature []byte) (uint64, error) {
+	var body io.Reader = bytes.NewReader(signature)
+
+	if IsArmored(signature) {
+		block, err := armor.Decode(body)
+		if err != nil {
+			return 0, err
+		}
+		body = block.Body
+	}
+
+	p, err := packet.NewReader(body).Next()
+	if err != nil {
+		return 0, err
+	}
+
+	switch sig := p.(type) {
+	case *packet.Signature:
+		if sig.IssuerKeyId != nil {
+			return *sig.IssuerKeyId, nil
+		}
+	case *packet.SignatureV3:
+		return sig.IssuerKeyId, nil
 	}
 
-	return true, ids
+	return 0, errors.New("signature doesn't have an issuer")
 }
@@ -0,0 +1,272 @@
+package gpg_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/RedCoolBeans/crane/util/gpg"
+	m "github.com/RedCoolBeans/crane/util/manifest"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// helper which creates a signing key, optionally one that has expired.
+func newKey(t *testing.T, name string, expired bool) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expired {
+		for _, ident := range e.Identities {
+			lifetime := uint32(60)
+			ident.SelfSignature.CreationTime = time.Now().Add(-time.Hour)
+			ident.SelfSignature.KeyLifetimeSecs = &lifetime
+		}
+	}
+
+	// NewEntity() leaves the self-signatures unsigned, SerializePrivate()
+	// takes care of that.
+	if err := e.SerializePrivate(ioutil.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// helper which writes the public part of `e` to `file` (binary), with a
+// revocation of the primary key.
+func writeRevokedKey(t *testing.T, e *openpgp.Entity, file string) {
+	var pub, primary bytes.Buffer
+	e.Serialize(&pub)
+	e.PrimaryKey.Serialize(&primary)
+
+	// Key revocations are made over the body of the primary key packet, i.e.
+	// without its (new format) header, and follow it directly.
+	body := primary.Bytes()[2:]
+	if primary.Bytes()[1] >= 192 {
+		body = primary.Bytes()[3:]
+	}
+
+	h := sha256.New()
+	e.PrimaryKey.SerializeSignaturePrefix(h)
+	h.Write(body)
+
+	revocation := &packet.Signature{
+		SigType:      packet.SigTypeKeyRevocation,
+		PubKeyAlgo:   e.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if err := revocation.Sign(h, e.PrivateKey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var key bytes.Buffer
+	key.Write(primary.Bytes())
+	revocation.Serialize(&key)
+	key.Write(pub.Bytes()[primary.Len():])
+
+	if err := ioutil.WriteFile(file, key.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// helper which writes the public part of `e` to `file`, armored or binary.
+func writeKey(t *testing.T, e *openpgp.Entity, file string, armored bool) {
+	var buf bytes.Buffer
+
+	if armored {
+		w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Serialize(w)
+		w.Close()
+	} else {
+		e.Serialize(&buf)
+	}
+
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crane-gpg-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keydir := path.Join(dir, "keys")
+	os.Mkdir(keydir, 0755)
+
+	alice := newKey(t, "alice", false)
+	bob := newKey(t, "bob", false)
+	expired := newKey(t, "expired", true)
+	untrusted := newKey(t, "mallory", false)
+	revoked := newKey(t, "revoked", false)
+
+	writeKey(t, alice, path.Join(keydir, "alice.asc"), true)
+	writeKey(t, bob, path.Join(keydir, "bob.gpg"), false)
+	writeKey(t, expired, path.Join(keydir, "expired.asc"), true)
+	writeRevokedKey(t, revoked, path.Join(keydir, "revoked.gpg"))
+
+	manifest := []byte("name: 'crane'\n")
+	if err := ioutil.WriteFile(path.Join(dir, m.MANIFEST), manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		signer *openpgp.Entity
+		ok     bool
+	}{
+		{alice, true},
+		{bob, true},
+		{expired, false},
+		{untrusted, false},
+		{revoked, false},
+	}
+
+	for i, tt := range tests {
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, tt.signer, bytes.NewReader(manifest), nil); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(path.Join(dir, "MANIFEST.yaml.sig"), sig.Bytes(), 0644)
+
+		ok, signer := gpg.Verify(keydir, "MANIFEST.yaml.sig", dir, false)
+		if ok != tt.ok {
+			t.Errorf("%d. signed by %s => %v, wanted: %v", i, tt.signer.PrimaryKey.KeyIdString(), ok, tt.ok)
+		}
+
+		if ok && signer.Primary != gpg.Fingerprint(tt.signer.PrimaryKey) {
+			t.Errorf("%d. fingerprint %s, wanted: %s", i, signer.Primary, gpg.Fingerprint(tt.signer.PrimaryKey))
+		}
+	}
+}
+
+// The openpgp package can't create signing subkeys (they need a
+// cross-signature), so testdata/ has one made with gpg: subkey.sig is made by
+// the signing subkey of subkey.asc, which is revoked in subkey-revoked.asc.
+func TestVerifyRevokedSubkey(t *testing.T) {
+	var tests = []struct {
+		pubkey string
+		ok     bool
+	}{
+		{"testdata/subkey.asc", true},
+		{"testdata/subkey-revoked.asc", false},
+	}
+
+	for i, tt := range tests {
+		ok, signer := gpg.Verify(tt.pubkey, "subkey.sig", "testdata", false)
+		if ok != tt.ok {
+			t.Errorf("%d. %s => %v, wanted: %v", i, tt.pubkey, ok, tt.ok)
+		}
+
+		if ok && signer.Fingerprint == signer.Primary {
+			t.Errorf("%d. %s => signed by the primary key, wanted: subkey", i, tt.pubkey)
+		}
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crane-gpg-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alice := newKey(t, "alice", false)
+	bob := newKey(t, "bob", false)
+	mallory := newKey(t, "mallory", false)
+
+	keydir := path.Join(dir, "keys")
+	os.Mkdir(keydir, 0755)
+	writeKey(t, alice, path.Join(keydir, "alice.asc"), true)
+	writeKey(t, bob, path.Join(keydir, "bob.asc"), true)
+
+	manifest := []byte("name: 'crane'\n")
+	ioutil.WriteFile(path.Join(dir, m.MANIFEST), manifest, 0644)
+
+	sign := func(e *openpgp.Entity, signed []byte) []byte {
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, e, bytes.NewReader(signed), nil); err != nil {
+			t.Fatal(err)
+		}
+		return sig.Bytes()
+	}
+
+	// alice signed twice (once in a multi-signature file), bob signed, mallory
+	// isn't trusted and bob's second signature is over different content.
+	multi := append(sign(alice, manifest), sign(mallory, manifest)...)
+	ioutil.WriteFile(path.Join(dir, "MANIFEST.yaml.sig"), multi, 0644)
+
+	sigdir := path.Join(dir, "MANIFEST.yaml.sig"+m.SIG_DIRSUFFIX)
+	os.Mkdir(sigdir, 0755)
+	ioutil.WriteFile(path.Join(sigdir, "alice.sig"), sign(alice, manifest), 0644)
+	ioutil.WriteFile(path.Join(sigdir, "bob.sig"), sign(bob, manifest), 0644)
+	ioutil.WriteFile(path.Join(sigdir, "bob-old.sig"), sign(bob, []byte("name: 'old'\n")), 0644)
+
+	signers := gpg.VerifyAll(keydir, "MANIFEST.yaml.sig", dir, false)
+	if len(signers) != 2 {
+		t.Errorf("%d distinct signers, wanted: 2", len(signers))
+	}
+}
+
+func TestVerifyAllFormats(t *testing.T) {
+	alice := newKey(t, "alice", false)
+	manifest := []byte("name: 'crane'\n")
+
+	var clearsigned bytes.Buffer
+	w, err := clearsign.Encode(&clearsigned, alice.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(manifest)
+	w.Close()
+
+	var binary bytes.Buffer
+	if err := openpgp.DetachSign(&binary, alice, bytes.NewReader(manifest), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		manifest []byte
+		sigfile  string
+		sig      []byte
+	}{
+		{clearsigned.Bytes(), "", nil},
+		{manifest, "MANIFEST.yaml.gpg", binary.Bytes()},
+		{manifest, "MANIFEST.yaml.sig", binary.Bytes()},
+	}
+
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "crane-gpg-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		writeKey(t, alice, path.Join(dir, "pubkey.asc"), true)
+		ioutil.WriteFile(path.Join(dir, m.MANIFEST), tt.manifest, 0644)
+		if tt.sigfile != "" {
+			ioutil.WriteFile(path.Join(dir, tt.sigfile), tt.sig, 0644)
+		}
+
+		signers := gpg.VerifyAll(path.Join(dir, "pubkey.asc"), "MANIFEST.yaml.sig", dir, false)
+		if len(signers) != 1 {
+			t.Errorf("%d. %d signers, wanted: 1", i, len(signers))
+		}
+	}
+}
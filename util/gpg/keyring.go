@@ -0,0 +1,206 @@
+package gpg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Keyring is an openpgp.KeyRing which only hands out keys that are currently
+// valid for signing: not expired, not revoked (including subkeys) and with
+// the signing usage flag set. Reasons for rejecting a key are recorded so they
+// can be reported.
+type Keyring struct {
+	Entities openpgp.EntityList
+	Rejected []string
+	now      time.Time
+}
+
+// ReadKeyring loads the trusted public keys from `keypath`, which is either a
+// single key file or a directory of key files. Both armored and binary keys
+// are accepted.
+func ReadKeyring(keypath string) (*Keyring, error) {
+	kr := &Keyring{now: time.Now()}
+
+	info, err := os.Stat(keypath)
+	if err != nil {
+		e := fmt.Sprintf("Could not open public key %s: %v", keypath, err)
+		return nil, errors.New(e)
+	}
+
+	files := []string{keypath}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(keypath)
+		if err != nil {
+			e := fmt.Sprintf("Could not read key directory %s: %v", keypath, err)
+			return nil, errors.New(e)
+		}
+
+		files = make([]string, 0)
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				files = append(files, path.Join(keypath, entry.Name()))
+			}
+		}
+	}
+
+	for _, file := range files {
+		entities, err := readKeyFile(file)
+		if err != nil {
+			e := fmt.Sprintf("Could not read public key %s: %v", file, err)
+			return nil, errors.New(e)
+		}
+		kr.Entities = append(kr.Entities, entities...)
+	}
+
+	if len(kr.Entities) == 0 {
+		e := fmt.Sprintf("No public keys found in %s", keypath)
+		return nil, errors.New(e)
+	}
+
+	return kr, nil
+}
+
+func readKeyFile(file string) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsArmored(data) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+
+	return openpgp.ReadKeyRing(bufio.NewReader(bytes.NewReader(data)))
+}
+
+// IsArmored reports whether `data` starts with an ASCII armor header.
+func IsArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP"))
+}
+
+// Fingerprint formats the fingerprint of `key` the way gpg prints it.
+func Fingerprint(key *packet.PublicKey) string {
+	return fmt.Sprintf("%X", key.Fingerprint)
+}
+
+// SigningKey returns the key of `signer` matching the `issuer` key id.
+func SigningKey(signer *openpgp.Entity, issuer uint64) *packet.PublicKey {
+	if signer.PrimaryKey.KeyId == issuer {
+		return signer.PrimaryKey
+	}
+
+	for _, subkey := range signer.Subkeys {
+		if subkey.PublicKey.KeyId == issuer {
+			return subkey.PublicKey
+		}
+	}
+
+	return signer.PrimaryKey
+}
+
+// primarySelfSignature returns the self-signature of the primary user id,
+// like openpgp.EntityList.KeysById does.
+func primarySelfSignature(e *openpgp.Entity) *packet.Signature {
+	var selfSig *packet.Signature
+
+	for _, ident := range e.Identities {
+		if selfSig == nil {
+			selfSig = ident.SelfSignature
+		} else if ident.SelfSignature.IsPrimaryId != nil && *ident.SelfSignature.IsPrimaryId {
+			return ident.SelfSignature
+		}
+	}
+
+	return selfSig
+}
+
+func (kr *Keyring) KeysById(id uint64) []openpgp.Key {
+	return kr.Entities.KeysById(id)
+}
+
+func (kr *Keyring) DecryptionKeys() []openpgp.Key {
+	return nil
+}
+
+// KeysByIdUsage returns the keys with the given id that may currently be used
+// for `requiredUsage`.
+func (kr *Keyring) KeysByIdUsage(id uint64, requiredUsage byte) []openpgp.Key {
+	keys := make([]openpgp.Key, 0)
+
+	for _, key := range kr.Entities.KeysById(id) {
+		if reason := kr.invalid(key, requiredUsage); reason != "" {
+			kr.Rejected = append(kr.Rejected,
+				fmt.Sprintf("%s: %s", Fingerprint(key.PublicKey), reason))
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// invalid returns why `key` can't be used, or "" if it can.
+func (kr *Keyring) invalid(key openpgp.Key, requiredUsage byte) string {
+	if len(key.Entity.Revocations) > 0 {
+		return "key has been revoked"
+	}
+
+	if key.SelfSignature == nil {
+		return "key has no self-signature"
+	}
+
+	// The primary key has to be valid for any of its subkeys to be valid.
+	if primary := primarySelfSignature(key.Entity); primary != nil && primary.KeyExpired(kr.now) {
+		return "primary key has expired"
+	}
+
+	if key.SelfSignature.SigType == packet.SigTypeSubkeyRevocation ||
+		key.SelfSignature.RevocationReason != nil || kr.subkeyRevoked(key) {
+		return "key has been revoked"
+	}
+
+	if key.SelfSignature.KeyExpired(kr.now) {
+		return "key has expired"
+	}
+
+	if key.SelfSignature.FlagsValid && requiredUsage&packet.KeyFlagSign != 0 &&
+		!key.SelfSignature.FlagSign {
+		return "key is not allowed to sign"
+	}
+
+	return ""
+}
+
+// subkeyRevoked checks for a revocation of a subkey. The openpgp package
+// files revocations following the binding signature with the signatures of
+// the last user id, so look for them there.
+func (kr *Keyring) subkeyRevoked(key openpgp.Key) bool {
+	if key.PublicKey == key.Entity.PrimaryKey {
+		return false
+	}
+
+	for _, ident := range key.Entity.Identities {
+		for _, sig := range ident.Signatures {
+			if sig.SigType != packet.SigTypeSubkeyRevocation {
+				continue
+			}
+
+			if key.Entity.PrimaryKey.VerifyKeySignature(key.PublicKey, sig) == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
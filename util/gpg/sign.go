@@ -0,0 +1,64 @@
+package gpg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// ReadSecretKey reads the first secret key from the armored (or binary)
+// `seckeyPath` and decrypts it with `passphrase` if needed.
+func ReadSecretKey(seckeyPath string, passphrase []byte) (*openpgp.Entity, error) {
+	data, err := ioutil.ReadFile(seckeyPath)
+	if err != nil {
+		e := fmt.Sprintf("Could not open secret key %s: %v", seckeyPath, err)
+		return nil, errors.New(e)
+	}
+
+	var entities openpgp.EntityList
+	if IsArmored(data) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		e := fmt.Sprintf("Could not read secret key %s: %v", seckeyPath, err)
+		return nil, errors.New(e)
+	}
+
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+
+		if entity.PrivateKey.Encrypted {
+			if err := entity.PrivateKey.Decrypt(passphrase); err != nil {
+				e := fmt.Sprintf("Could not decrypt secret key %s: wrong passphrase?", seckeyPath)
+				return nil, errors.New(e)
+			}
+		}
+
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
+					e := fmt.Sprintf("Could not decrypt secret subkey %s: wrong passphrase?", seckeyPath)
+					return nil, errors.New(e)
+				}
+			}
+		}
+
+		return entity, nil
+	}
+
+	e := fmt.Sprintf("No secret key found in %s", seckeyPath)
+	return nil, errors.New(e)
+}
+
+// Sign writes an armored detached signature over `signed` made by `signer`.
+func Sign(signer *openpgp.Entity, signed []byte, w io.Writer) error {
+	return openpgp.ArmoredDetachSign(w, signer, bytes.NewReader(signed), nil)
+}
@@ -0,0 +1,76 @@
+package hash
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"os"
+
+	"github.com/RedCoolBeans/crane/util/manifest"
+	"github.com/dchest/blake2b"
+)
+
+const (
+	SHA256      = "sha256"
+	SHA512      = "sha512"
+	BLAKE2B_256 = "blake2b-256"
+
+	DEFAULT_ALGO = SHA256 // Default hashing algorithm used for verifying files
+)
+
+// Supported hashing algorithms, strongest first.
+var Algorithms = []string{SHA512, BLAKE2B_256, SHA256}
+
+// New returns a new hash.Hash for the given algorithm.
+func New(algo string) (gohash.Hash, error) {
+	switch algo {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case BLAKE2B_256:
+		return blake2b.New256(), nil
+	}
+
+	e := fmt.Sprintf("Unsupported hashing algorithm: %s", algo)
+	return nil, errors.New(e)
+}
+
+// File calculates the hash of the file at `filePath` using `algo`.
+func File(filePath string, algo string) ([]byte, error) {
+	var result []byte
+
+	hash, err := New(algo)
+	if err != nil {
+		return result, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return result, err
+	}
+
+	return hash.Sum(result), nil
+}
+
+// Present returns the algorithms for which a checksum of `file` is recorded
+// in `contents`, strongest first.
+func Present(contents []interface{}, file string) []string {
+	present := make([]string, 0)
+
+	for _, algo := range Algorithms {
+		if manifest.HashFor(contents, file, algo) != "" {
+			present = append(present, algo)
+		}
+	}
+
+	return present
+}
@@ -0,0 +1,63 @@
+package hash_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/hash"
+)
+
+func TestFile(t *testing.T) {
+	var tests = []struct {
+		algo string
+		sum  string
+	}{
+		{"sha256", "98ea6e4f216f2fb4b69fff9b3a44842c38686ca685f3f55dc48c5d3fb1107be4"},
+		{"sha512", "d78abb0542736865f94704521609c230dac03a2f369d043ac212d6933b91410e06399e37f9c5cc88436a31737330c1c8eccb2c2f9f374d62f716432a32d50fac"},
+		{"blake2b-256", "de9543b2ae1b2b87434a730727db17f5ac8b8c020b84a5cb8c5fbcc1423443ba"},
+		{"md5", ""},
+	}
+
+	f, err := ioutil.TempFile("", "crane-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("hi\n")
+	f.Close()
+
+	for i, tt := range tests {
+		sum, err := hash.File(f.Name(), tt.algo)
+		if tt.sum == "" && err == nil {
+			t.Errorf("%d. %q => no error, wanted one", i, tt.algo)
+		} else if s := fmt.Sprintf("%x", sum); tt.sum != "" && s != tt.sum {
+			t.Errorf("%d. %q => %q, wanted: %q", i, tt.algo, s, tt.sum)
+		}
+	}
+}
+
+func TestPresent(t *testing.T) {
+	contents := []interface{}{
+		map[interface{}]interface{}{"path": "/a", "sha256": "x", "blake2b-256": "y"},
+		map[interface{}]interface{}{"path": "/b", "sha256": "x", "sha512": "z", "blake2b-256": "y"},
+		map[interface{}]interface{}{"path": "/c", "mode": 0644},
+	}
+
+	var tests = []struct {
+		file  string
+		algos []string
+	}{
+		{"/a", []string{"blake2b-256", "sha256"}},
+		{"/b", []string{"sha512", "blake2b-256", "sha256"}},
+		{"/c", []string{}},
+	}
+
+	for i, tt := range tests {
+		if algos := hash.Present(contents, tt.file); !reflect.DeepEqual(algos, tt.algos) {
+			t.Errorf("%d. %q => %q, wanted: %q", i, tt.file, algos, tt.algos)
+		}
+	}
+}
@@ -2,28 +2,52 @@ package hash
 
 import (
 	"fmt"
+	gohash "hash"
 
-	"github.com/RedCoolBeans/crane/util/logging"
 	"github.com/RedCoolBeans/crane/util/manifest"
 )
 
-func Verify(contents []interface{}, fullsrc string, src string, algo string, strict bool) bool {
-	manifestHash := manifest.HashFor(contents, src, algo)
-	if manifestHash == "" {
-		// If we're in 'strict' mode, require a hash for each file or bail out.
-		// Otherwise just return a non-match and let the caller decide what to do.
-		if strict {
-			logging.PrError("Could not find hash for %s", src)
-		} else {
-			return false
+// Verifier hashes everything written to it with each of its algorithms, so
+// a file can be verified in the same stream it's copied in.
+type Verifier struct {
+	algos  []string
+	hashes []gohash.Hash
+}
+
+func NewVerifier(algos []string) (*Verifier, error) {
+	v := &Verifier{algos: algos}
+
+	for _, algo := range algos {
+		h, err := New(algo)
+		if err != nil {
+			return nil, err
 		}
+		v.hashes = append(v.hashes, h)
 	}
 
-	if fileHash, err := FileSha256(fullsrc); err != nil {
-		logging.PrError("Could not calculate hash for %s: %v", fullsrc, err)
-	} else {
-		return (manifestHash == fmt.Sprintf("%x", fileHash))
+	return v, nil
+}
+
+func (v *Verifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		h.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// Mismatches returns the algorithms for which the data written so far doesn't
+// match the checksum recorded for `src` in `contents` (or for which there is
+// none recorded).
+func (v *Verifier) Mismatches(contents []interface{}, src string) []string {
+	mismatches := make([]string, 0)
+
+	for i, algo := range v.algos {
+		manifestHash := manifest.HashFor(contents, src, algo)
+		if manifestHash == "" || manifestHash != fmt.Sprintf("%x", v.hashes[i].Sum(nil)) {
+			mismatches = append(mismatches, algo)
+		}
 	}
 
-	return false
+	return mismatches
 }
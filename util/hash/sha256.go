@@ -1,23 +1,5 @@
 package hash
 
-import (
-	"crypto/sha256"
-	"io"
-	"os"
-)
-
 func FileSha256(filePath string) ([]byte, error) {
-	var result []byte
-	file, err := os.Open(filePath)
-	if err != nil {
-		return result, err
-	}
-	defer file.Close()
-
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return result, err
-	}
-
-	return hash.Sum(result), nil
+	return File(filePath, SHA256)
 }
@@ -0,0 +1,29 @@
+package hash
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/RedCoolBeans/crane/util/manifest"
+)
+
+// Stale returns the paths in `contents` for which any of the recorded checksums
+// doesn't match the file in `dir` (or which don't exist at all).
+func Stale(contents []interface{}, dir string) []string {
+	stale := make([]string, 0)
+
+	for _, c := range contents {
+		entry := c.(map[interface{}]interface{})
+		file := entry["path"].(string)
+
+		for _, algo := range Present(contents, file) {
+			manifestHash := manifest.HashFor(contents, file, algo)
+			if fileHash, err := File(path.Join(dir, file), algo); err != nil || manifestHash != fmt.Sprintf("%x", fileHash) {
+				stale = append(stale, file)
+				break
+			}
+		}
+	}
+
+	return stale
+}
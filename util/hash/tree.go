@@ -0,0 +1,122 @@
+package hash
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/RedCoolBeans/crane/util/manifest"
+)
+
+// TreeSha256 computes a Merkle tree hash over all installable files in `root`
+// (i.e. clonedir/prefix), covering their paths, types, exec bits, symlink
+// targets and contents. Files which are skipped or ignored aren't part of it.
+// Paths are relative to `clonedir`, like they are in `contents`.
+//
+// Every node hashes to sha256 over:
+//
+//	file:      "file\0" + "0755" or "0644" + "\0" + hex sha256 of contents
+//	symlink:   "link\0" + target
+//	directory: "dir\0" + for every entry, sorted by name:
+//	                     name + "\0" + hex hash of entry + "\n"
+func TreeSha256(root string, clonedir string, ignores []interface{}) (string, error) {
+	sum, err := treeNode(root, clonedir, ignores)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sum), nil
+}
+
+func treeNode(fullsrc string, clonedir string, ignores []interface{}) ([]byte, error) {
+	info, err := os.Lstat(fullsrc)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(fullsrc)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(h, "link\x00%s", target)
+	case info.IsDir():
+		entries, err := ioutil.ReadDir(fullsrc)
+		if err != nil {
+			return nil, err
+		}
+
+		// ReadDir() returns the entries sorted by name.
+		fmt.Fprint(h, "dir\x00")
+		for _, entry := range entries {
+			child := path.Join(fullsrc, entry.Name())
+			src := "/" + strings.TrimPrefix(strings.TrimPrefix(child, clonedir), "/")
+
+			if entry.Name() == ".git" || manifest.IsSkipped(entry.Name()) || manifest.IsIgnored(ignores, src) {
+				continue
+			}
+
+			sum, err := treeNode(child, clonedir, ignores)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(h, "%s\x00%x\n", entry.Name(), sum)
+		}
+	case info.Mode().IsRegular():
+		sum, err := FileSha256(fullsrc)
+		if err != nil {
+			return nil, err
+		}
+
+		mode := "0644"
+		if info.Mode()&0111 != 0 {
+			mode = "0755"
+		}
+		fmt.Fprintf(h, "file\x00%s\x00%x", mode, sum)
+	default:
+		e := fmt.Sprintf("%s is not a regular file, directory or symlink", fullsrc)
+		return nil, errors.New(e)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// Files returns the regular files below `root` which would be installed, as
+// paths relative to `clonedir`, sorted by name.
+func Files(root string, clonedir string, ignores []interface{}) ([]string, error) {
+	files := make([]string, 0)
+
+	err := filepath.Walk(root, func(fullsrc string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fullsrc == root {
+			return nil
+		}
+
+		src := "/" + strings.TrimPrefix(strings.TrimPrefix(fullsrc, clonedir), "/")
+		if info.Name() == ".git" || manifest.IsSkipped(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !manifest.IsIgnored(ignores, src) && info.Mode().IsRegular() {
+			files = append(files, src)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
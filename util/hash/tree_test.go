@@ -0,0 +1,86 @@
+package hash_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/hash"
+)
+
+func TestTreeSha256(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		modify  func(dir string) error
+		ignores []interface{}
+		changed bool
+	}{
+		{"unchanged", func(dir string) error { return nil }, nil, false},
+		{"README.md is skipped", func(dir string) error {
+			return ioutil.WriteFile(path.Join(dir, "README.md"), []byte("changed"), 0644)
+		}, nil, false},
+		{"modified file", func(dir string) error {
+			return ioutil.WriteFile(path.Join(dir, "usr/bin/tool"), []byte("evil"), 0755)
+		}, nil, true},
+		{"added file", func(dir string) error {
+			return ioutil.WriteFile(path.Join(dir, "usr/bin/extra"), []byte("extra"), 0644)
+		}, nil, true},
+		{"added file, ignored", func(dir string) error {
+			return ioutil.WriteFile(path.Join(dir, "usr/bin/extra"), []byte("extra"), 0644)
+		}, []interface{}{"/usr/bin/extra"}, false},
+		{"removed file", func(dir string) error {
+			return os.Remove(path.Join(dir, "etc/tool.conf"))
+		}, nil, true},
+		{"exec bit", func(dir string) error {
+			return os.Chmod(path.Join(dir, "etc/tool.conf"), 0755)
+		}, nil, true},
+		{"group write", func(dir string) error {
+			return os.Chmod(path.Join(dir, "etc/tool.conf"), 0664)
+		}, nil, false},
+		{"symlink target", func(dir string) error {
+			os.Remove(path.Join(dir, "usr/bin/link"))
+			return os.Symlink("other", path.Join(dir, "usr/bin/link"))
+		}, nil, true},
+	}
+
+	for i, tt := range tests {
+		dir := newTree(t)
+		before, err := hash.TreeSha256(dir, dir, tt.ignores)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tt.modify(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		after, err := hash.TreeSha256(dir, dir, tt.ignores)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if changed := before != after; changed != tt.changed {
+			t.Errorf("%d. %q => changed: %v, wanted: %v", i, tt.desc, changed, tt.changed)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func newTree(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "crane-tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.MkdirAll(path.Join(dir, "usr/bin"), 0755)
+	os.MkdirAll(path.Join(dir, "etc"), 0755)
+	os.MkdirAll(path.Join(dir, ".git"), 0755)
+	ioutil.WriteFile(path.Join(dir, "usr/bin/tool"), []byte("tool"), 0755)
+	ioutil.WriteFile(path.Join(dir, "etc/tool.conf"), []byte("conf"), 0644)
+	ioutil.WriteFile(path.Join(dir, "README.md"), []byte("readme"), 0644)
+	ioutil.WriteFile(path.Join(dir, ".git/HEAD"), []byte("ref"), 0644)
+	os.Symlink("tool", path.Join(dir, "usr/bin/link"))
+
+	return dir
+}
@@ -0,0 +1,277 @@
+package lfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+const MEDIA_TYPE = "application/vnd.git-lfs+json"
+
+// Credentials used for HTTP basic authentication against the LFS server.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+type batchObject struct {
+	Oid     string                 `json:"oid"`
+	Size    int64                  `json:"size"`
+	Actions map[string]batchAction `json:"actions,omitempty"`
+	Error   *batchError            `json:"error,omitempty"`
+}
+
+type batchAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type batchError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type batchRequest struct {
+	Operation string        `json:"operation"`
+	Transfers []string      `json:"transfers"`
+	Objects   []batchObject `json:"objects"`
+}
+
+type batchResponse struct {
+	Objects []batchObject `json:"objects"`
+}
+
+// Endpoint derives the LFS server URL from a repository URL, following the
+// same rules as git-lfs: HTTP(S) remotes get `.git/info/lfs` appended, SSH
+// remotes (ssh://user@host[:port]/path, ssh://user@host:path or user@host:path)
+// are mapped to HTTPS. Any credentials in an HTTP(S) URL are split off and
+// returned separately; SSH remotes get none, as git-lfs-authenticate isn't
+// supported.
+func Endpoint(repository string) (string, Credentials, error) {
+	creds := Credentials{}
+	repository = strings.TrimSuffix(repository, "/")
+
+	if strings.HasPrefix(repository, "http://") || strings.HasPrefix(repository, "https://") {
+		u, err := url.Parse(repository)
+		if err != nil {
+			return "", creds, err
+		}
+
+		if u.User != nil {
+			creds.Username = u.User.Username()
+			creds.Password, _ = u.User.Password()
+			u.User = nil
+		}
+
+		u.Path = path.Clean(u.Path)
+		if !strings.HasSuffix(u.Path, ".git") {
+			u.Path = u.Path + ".git"
+		}
+		u.Path = u.Path + "/info/lfs"
+
+		return u.String(), creds, nil
+	}
+
+	// SSH URLs proper (ssh://user@host:port/path) are mapped onto HTTPS on the
+	// default port, the SSH port has nothing to do with the LFS server.
+	if strings.HasPrefix(repository, "ssh://") {
+		if u, err := url.Parse(repository); err == nil && u.Hostname() != "" {
+			repopath := strings.TrimPrefix(path.Clean(u.Path), "/")
+			if !strings.HasSuffix(repopath, ".git") {
+				repopath = repopath + ".git"
+			}
+
+			return fmt.Sprintf("https://%s/%s/info/lfs", u.Hostname(), repopath), creds, nil
+		}
+	}
+
+	// scp-like SSH (user@host:path, also with ssh://): strip the scheme and
+	// user, then split host from path on the first ':' or '/'.
+	hostpath := strings.TrimPrefix(repository, "ssh://")
+	if i := strings.Index(hostpath, "@"); i >= 0 {
+		hostpath = hostpath[i+1:]
+	}
+
+	i := strings.IndexAny(hostpath, ":/")
+	if i < 1 {
+		e := fmt.Sprintf("Cannot derive LFS endpoint from %s", repository)
+		return "", creds, errors.New(e)
+	}
+
+	repopath := strings.TrimPrefix(hostpath[i+1:], "/")
+	if !strings.HasSuffix(repopath, ".git") {
+		repopath = repopath + ".git"
+	}
+
+	return fmt.Sprintf("https://%s/%s/info/lfs", hostpath[:i], repopath), creds, nil
+}
+
+// Resolve downloads the objects for all `pointers` from the LFS server at
+// `endpoint` and replaces each pointer file with its verified object.
+func Resolve(pointers []Pointer, endpoint string, creds Credentials) error {
+	if len(pointers) == 0 {
+		return nil
+	}
+
+	objects, err := batch(pointers, endpoint, creds)
+	if err != nil {
+		return err
+	}
+
+	byOid := make(map[string]batchObject)
+	for _, o := range objects {
+		byOid[o.Oid] = o
+	}
+
+	for _, p := range pointers {
+		o, ok := byOid[p.Oid]
+		if !ok {
+			e := fmt.Sprintf("LFS server returned no object for %s (%s)", p.Path, p.Oid)
+			return errors.New(e)
+		}
+
+		if o.Error != nil {
+			e := fmt.Sprintf("LFS server refused %s (%s): %d %s", p.Path, p.Oid, o.Error.Code, o.Error.Message)
+			return errors.New(e)
+		}
+
+		download, ok := o.Actions["download"]
+		if !ok {
+			e := fmt.Sprintf("LFS server returned no download action for %s (%s)", p.Path, p.Oid)
+			return errors.New(e)
+		}
+
+		if err := fetch(p, download, creds); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func batch(pointers []Pointer, endpoint string, creds Credentials) ([]batchObject, error) {
+	req := batchRequest{
+		Operation: "download",
+		Transfers: []string{"basic"},
+		Objects:   make([]batchObject, 0, len(pointers)),
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range pointers {
+		if !seen[p.Oid] {
+			seen[p.Oid] = true
+			req.Objects = append(req.Objects, batchObject{Oid: p.Oid, Size: p.Size})
+		}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest("POST", endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Accept", MEDIA_TYPE)
+	httpReq.Header.Set("Content-Type", MEDIA_TYPE)
+	if creds.Username != "" {
+		httpReq.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		e := fmt.Sprintf("LFS batch request to %s failed: %s", endpoint, err)
+		return nil, errors.New(e)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := fmt.Sprintf("LFS batch request to %s failed: %s", endpoint, resp.Status)
+		return nil, errors.New(e)
+	}
+
+	var batchResp batchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batchResp); err != nil {
+		e := fmt.Sprintf("Invalid LFS batch response from %s: %s", endpoint, err)
+		return nil, errors.New(e)
+	}
+
+	return batchResp.Objects, nil
+}
+
+// fetch downloads a single object next to the pointer file, verifies its oid
+// and size and then renames it over the pointer.
+func fetch(p Pointer, action batchAction, creds Credentials) error {
+	req, err := http.NewRequest("GET", action.Href, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(action.Header) > 0 {
+		for k, v := range action.Header {
+			req.Header.Set(k, v)
+		}
+	} else if creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		e := fmt.Sprintf("Could not download LFS object for %s: %s", p.Path, err)
+		return errors.New(e)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := fmt.Sprintf("Could not download LFS object for %s: %s", p.Path, resp.Status)
+		return errors.New(e)
+	}
+
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(path.Dir(p.Path), ".crane-lfs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	// Reading one byte more than expected is enough to reject an object
+	// that's too large, without downloading all of it.
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, p.Size+1))
+	if err != nil {
+		e := fmt.Sprintf("Could not download LFS object for %s: %s", p.Path, err)
+		return errors.New(e)
+	}
+
+	if oid := fmt.Sprintf("%x", h.Sum(nil)); oid != p.Oid || size != p.Size {
+		e := fmt.Sprintf("LFS object mismatch for %s: got %s (%d bytes), wanted %s (%d bytes)",
+			p.Path, oid, size, p.Oid, p.Size)
+		return errors.New(e)
+	}
+
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p.Path)
+}
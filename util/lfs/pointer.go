@@ -0,0 +1,97 @@
+package lfs
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	POINTER_VERSION = "version https://git-lfs.github.com/spec/v1"
+	MAX_POINTER     = 1024 // Pointer files are always smaller than this
+)
+
+var oidRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Pointer describes a Git LFS pointer file found in a checkout.
+type Pointer struct {
+	Path string // Full path to the pointer file
+	Oid  string // SHA256 of the object
+	Size int64  // Size of the object in bytes
+}
+
+// ParsePointer tries to parse `data` as a Git LFS pointer, returning false if
+// it isn't one.
+func ParsePointer(data []byte) (Pointer, bool) {
+	p := Pointer{Size: -1}
+
+	if !bytes.HasPrefix(data, []byte(POINTER_VERSION+"\n")) {
+		return p, false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			return p, false
+		}
+
+		switch fields[0] {
+		case "oid":
+			if !strings.HasPrefix(fields[1], "sha256:") {
+				return p, false
+			}
+			p.Oid = strings.TrimPrefix(fields[1], "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return p, false
+			}
+			p.Size = size
+		}
+	}
+
+	if !oidRegexp.MatchString(p.Oid) || p.Size < 0 {
+		return p, false
+	}
+
+	return p, true
+}
+
+// FindPointers walks `dir` and returns all Git LFS pointer files in it.
+func FindPointers(dir string) ([]Pointer, error) {
+	pointers := make([]Pointer, 0)
+
+	err := filepath.Walk(dir, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if !info.Mode().IsRegular() || info.Size() > MAX_POINTER {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(fullpath)
+		if err != nil {
+			return err
+		}
+
+		if p, ok := ParsePointer(data); ok {
+			p.Path = fullpath
+			pointers = append(pointers, p)
+		}
+
+		return nil
+	})
+
+	return pointers, err
+}
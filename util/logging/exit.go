@@ -0,0 +1,34 @@
+package logging
+
+import "sync"
+
+var (
+	exitHooks []func()
+	exitLock  sync.Mutex // Guards exitHooks
+	exitOnce  sync.Once
+)
+
+// AtExit registers `hook` to be run by PrError and PrFatal before the
+// process terminates. Hooks are run in reverse order of registration, and
+// must not call PrError or PrFatal themselves.
+func AtExit(hook func()) {
+	exitLock.Lock()
+	defer exitLock.Unlock()
+
+	exitHooks = append(exitHooks, hook)
+}
+
+// runExitHooks runs all registered hooks, but only once. Callers on other
+// goroutines block until the hooks have finished, so the process doesn't
+// exit halfway through i.e. a rollback.
+func runExitHooks() {
+	exitOnce.Do(func() {
+		exitLock.Lock()
+		hooks := exitHooks
+		exitLock.Unlock()
+
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hooks[i]()
+		}
+	})
+}
@@ -7,11 +7,13 @@ import (
 
 func PrError(format string, v ...interface{}) {
 	fmt.Printf("==> Error: %s\n", fmt.Sprintf(format, v...))
+	runExitHooks()
 	os.Exit(1)
 }
 
 func PrFatal(format string, v ...interface{}) {
 	str := fmt.Sprintf("\n===> Fatal: %s\n", fmt.Sprintf(format, v...))
+	runExitHooks()
 	panic(str)
 }
 
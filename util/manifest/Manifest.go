@@ -1,5 +1,10 @@
 package manifest
 
+const (
+	MANIFEST      = "MANIFEST.yaml"
+	SIG_DIRSUFFIX = ".d" // MANIFEST.yaml.sig.d/ holds additional signatures
+)
+
 type Manifest struct {
 	Name         string
 	Maintainer   string
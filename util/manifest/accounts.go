@@ -0,0 +1,199 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/RedCoolBeans/crane/util/logging"
+	"github.com/RedCoolBeans/crane/util/users"
+)
+
+const (
+	DEFAULT_HOME  = "/nonexistent"  // Home directory of users without one in the manifest
+	DEFAULT_SHELL = "/sbin/nologin" // Shell of users without one in the manifest
+)
+
+// Users returns the users to create in the destination.
+func Users(manifest map[interface{}]interface{}) []interface{} {
+	if users, ok := manifest["users"].([]interface{}); ok {
+		return users
+	}
+
+	return make([]interface{}, 0)
+}
+
+// Groups returns the groups to create in the destination.
+func Groups(manifest map[interface{}]interface{}) []interface{} {
+	if groups, ok := manifest["groups"].([]interface{}); ok {
+		return groups
+	}
+
+	return make([]interface{}, 0)
+}
+
+// AccountId returns the uid or gid set with `field` of a user or group,
+// or -1 if it's not set.
+func AccountId(account map[interface{}]interface{}, field string) int {
+	if id, ok := account[field].(int); ok {
+		return id
+	}
+
+	return -1
+}
+
+// AccountString returns `field` of a user or group, or `def` if it's not set.
+func AccountString(account map[interface{}]interface{}, field string, def string) string {
+	if account[field] != nil {
+		return fmt.Sprint(account[field])
+	}
+
+	return def
+}
+
+// AccountList returns the list `field` (i.e. group members) of a user or group.
+func AccountList(account map[interface{}]interface{}, field string) []string {
+	list := make([]string, 0)
+
+	if values, ok := account[field].([]interface{}); ok {
+		for _, value := range values {
+			list = append(list, fmt.Sprint(value))
+		}
+	}
+
+	return list
+}
+
+// IsSystemAccount checks if ids for the user or group are to be allocated
+// from the system range, which is the default.
+func IsSystemAccount(account map[interface{}]interface{}) bool {
+	if system, ok := account["system"].(bool); ok {
+		return system
+	}
+
+	return true
+}
+
+// CreateAccounts adds the groups and users declared in the manifest to
+// `accounts`, so files can be owned by them. Accounts which already exist are
+// checked to match. The manifest's `destination` plays no part: accounts
+// always belong to the root they were loaded from.
+func CreateAccounts(manifest map[interface{}]interface{}, accounts *users.Database) error {
+	for _, g := range Groups(manifest) {
+		entry := g.(map[interface{}]interface{})
+		group := users.Group{
+			Name:    fmt.Sprint(entry["name"]),
+			Gid:     AccountId(entry, "gid"),
+			Members: AccountList(entry, "members"),
+		}
+
+		if _, ok := accounts.LookupGroup(group.Name); !ok {
+			log.PrInfo("Creating group %s", group.Name)
+		}
+		if _, err := accounts.AddGroup(group, idRange(entry)); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range Users(manifest) {
+		entry := u.(map[interface{}]interface{})
+		user := users.User{
+			Name:  fmt.Sprint(entry["name"]),
+			Uid:   AccountId(entry, "uid"),
+			Gid:   -1,
+			Gecos: AccountString(entry, "gecos", ""),
+			Home:  AccountString(entry, "home", DEFAULT_HOME),
+			Shell: AccountString(entry, "shell", DEFAULT_SHELL),
+		}
+		_, exists := accounts.LookupUser(user.Name)
+
+		// New users without a primary group get a group of their own, with
+		// the same id if it's available.
+		group := AccountString(entry, "group", "")
+		if group == "" && !exists {
+			private := users.Group{Name: user.Name, Gid: -1}
+			if _, ok := accounts.LookupGroup(user.Name); !ok && user.Uid != -1 && !accounts.GidUsed(user.Uid) {
+				private.Gid = user.Uid
+			}
+
+			g, err := accounts.AddGroup(private, idRange(entry))
+			if err != nil {
+				return err
+			}
+			user.Gid = g.Gid
+		} else if group != "" {
+			gid, err := accounts.Gid(group)
+			if err != nil {
+				return err
+			}
+			user.Gid = gid
+		}
+
+		if !exists {
+			log.PrInfo("Creating user %s", user.Name)
+		}
+		if _, err := accounts.AddUser(user, idRange(entry)); err != nil {
+			return err
+		}
+
+		for _, supplementary := range AccountList(entry, "groups") {
+			if err := accounts.AddMember(supplementary, user.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func idRange(account map[interface{}]interface{}) users.Range {
+	if IsSystemAccount(account) {
+		return users.SystemRange
+	}
+
+	return users.UserRange
+}
+
+func ValidateAccountFields(manifest map[interface{}]interface{}) error {
+	for _, kind := range []string{"user", "group"} {
+		accounts := Users(manifest)
+		if kind == "group" {
+			accounts = Groups(manifest)
+		}
+
+		for i, a := range accounts {
+			account, ok := a.(map[interface{}]interface{})
+			if !ok || account["name"] == nil {
+				err := fmt.Sprintf("required field \"name\" not found for %s #%d", kind, i+1)
+				return errors.New(err)
+			}
+
+			for _, field := range []string{"uid", "gid"} {
+				if account[field] != nil && AccountId(account, field) < 0 {
+					err := fmt.Sprintf("field %q of %s %q must be a number >= 0", field, kind, account["name"])
+					return errors.New(err)
+				}
+			}
+
+			// Fields end up in etc/passwd and etc/group as they are.
+			var err error
+			if kind == "user" {
+				err = users.User{
+					Name:  fmt.Sprint(account["name"]),
+					Gecos: AccountString(account, "gecos", ""),
+					Home:  AccountString(account, "home", ""),
+					Shell: AccountString(account, "shell", ""),
+				}.Validate()
+			} else {
+				err = users.Group{
+					Name:    fmt.Sprint(account["name"]),
+					Members: AccountList(account, "members"),
+				}.Validate()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
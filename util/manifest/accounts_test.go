@@ -0,0 +1,104 @@
+package manifest_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/manifest"
+	"github.com/RedCoolBeans/crane/util/users"
+)
+
+func TestValidateAccountFields(t *testing.T) {
+	var tests = []struct {
+		field    string
+		accounts []interface{}
+		ok       bool
+	}{
+		{"users", []interface{}{map[interface{}]interface{}{"name": "www", "uid": 80, "gecos": "Web server, 2nd floor", "home": "/var/www"}}, true},
+		{"users", []interface{}{map[interface{}]interface{}{"uid": 80}}, false},
+		{"users", []interface{}{map[interface{}]interface{}{"name": "www", "uid": -1}}, false},
+		{"users", []interface{}{map[interface{}]interface{}{"name": "www:x"}}, false},
+		{"users", []interface{}{map[interface{}]interface{}{"name": "www,db"}}, false},
+		{"users", []interface{}{map[interface{}]interface{}{"name": "www", "gecos": "Web\ntoor:x:0:0::/root:/bin/sh"}}, false},
+		{"users", []interface{}{map[interface{}]interface{}{"name": "www", "gecos": "Web\ntoor:!:17000::::::"}}, false},
+		{"users", []interface{}{map[interface{}]interface{}{"name": "www", "home": "/var/www:0"}}, false},
+		{"users", []interface{}{map[interface{}]interface{}{"name": "www", "shell": "/bin/sh\t"}}, false},
+		{"groups", []interface{}{map[interface{}]interface{}{"name": "www", "gid": 82, "members": []interface{}{"www", "db"}}}, true},
+		{"groups", []interface{}{map[interface{}]interface{}{"name": "www\n"}}, false},
+		{"groups", []interface{}{map[interface{}]interface{}{"name": "www", "members": []interface{}{"www,root"}}}, false},
+		{"groups", []interface{}{map[interface{}]interface{}{"name": "www", "members": []interface{}{"www:x"}}}, false},
+	}
+
+	for i, tt := range tests {
+		m := map[interface{}]interface{}{tt.field: tt.accounts}
+		if err := manifest.ValidateAccountFields(m); (err == nil) != tt.ok {
+			t.Errorf("%d. %v => %v, wanted ok: %v", i, tt.accounts, err, tt.ok)
+		}
+	}
+}
+
+func TestCreateAccounts(t *testing.T) {
+	var tests = []struct {
+		manifest map[interface{}]interface{}
+		passwd   string // expected line in etc/passwd of the root
+		group    string // expected line in etc/group of the root
+	}{
+		{
+			map[interface{}]interface{}{
+				"users": []interface{}{map[interface{}]interface{}{"name": "www", "uid": 80}},
+			},
+			"www:x:80:80::/nonexistent:/sbin/nologin", "www:x:80:",
+		},
+		{
+			map[interface{}]interface{}{
+				"destination": "/opt/www",
+				"groups":      []interface{}{map[interface{}]interface{}{"name": "web", "gid": 82}},
+				"users":       []interface{}{map[interface{}]interface{}{"name": "www", "uid": 80, "group": "web", "home": "/opt/www"}},
+			},
+			"www:x:80:82::/opt/www:/sbin/nologin", "web:x:82:",
+		},
+	}
+
+	for i, tt := range tests {
+		root, err := ioutil.TempDir("", "crane-accounts")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+
+		os.MkdirAll(path.Join(root, "etc"), 0755)
+		ioutil.WriteFile(path.Join(root, users.PASSWD), []byte("root:x:0:0:root:/root:/bin/sh\n"), 0644)
+		ioutil.WriteFile(path.Join(root, users.GROUP), []byte("root:x:0:\n"), 0644)
+
+		accounts, err := users.Load(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := manifest.CreateAccounts(tt.manifest, accounts); err != nil {
+			t.Errorf("%d. %s", i, err)
+			continue
+		}
+		if err := accounts.Write(root); err != nil {
+			t.Fatal(err)
+		}
+
+		passwd, _ := ioutil.ReadFile(path.Join(root, users.PASSWD))
+		if !strings.Contains(string(passwd), tt.passwd+"\n") {
+			t.Errorf("%d. etc/passwd => %q, expected %q", i, passwd, tt.passwd)
+		}
+		group, _ := ioutil.ReadFile(path.Join(root, users.GROUP))
+		if !strings.Contains(string(group), tt.group+"\n") {
+			t.Errorf("%d. etc/group => %q, expected %q", i, group, tt.group)
+		}
+
+		// The package's destination doesn't get accounts of its own.
+		if destination, ok := tt.manifest["destination"].(string); ok {
+			if _, err := os.Stat(path.Join(root, destination, users.PASSWD)); err == nil {
+				t.Errorf("%d. accounts created in %s", i, destination)
+			}
+		}
+	}
+}
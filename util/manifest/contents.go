@@ -1,9 +1,6 @@
 package manifest
 
-const (
-	DEFAULT_FILEMODE = 0644
-	DEFAULT_DIRMODE  = 0755
-)
+import "fmt"
 
 // Contents() takes a Manifest and returns the array of contents. Omitted values
 // (i.e. filemode) are ignored.
@@ -19,26 +16,21 @@ func Contents(manifest map[interface{}]interface{}) []interface{} {
 	return contents
 }
 
-// Return the filemode for a given file. If no mode is found
-// the default is returned returns.
-func ModeFor(contents []interface{}, file string, isdir bool) int {
+// Return the filemode for a given file. Files without a mode, or which aren't
+// listed in `contents` at all, get `fallback` (i.e. the mode recorded in git),
+// which may be 0 to leave them be.
+func ModeFor(contents []interface{}, file string, fallback int) int {
 	for _, c := range contents {
 		entry := c.(map[interface{}]interface{})
 		if entry["path"].(string) == file {
 			if mode, ok := entry["mode"].(int); ok {
 				return mode
-			} else {
-				if isdir {
-					return DEFAULT_DIRMODE
-				} else {
-					return DEFAULT_FILEMODE
-				}
 			}
+			break
 		}
 	}
 
-	// file wasn't found
-	return 0
+	return fallback
 }
 
 // Returns the hash for a given file matching the algorithm.
@@ -56,3 +48,70 @@ func HashFor(contents []interface{}, file string, algo string) string {
 
 	return ""
 }
+
+// Policies for files which already exist in the destination, but weren't
+// installed by Crane.
+const (
+	OVERWRITE_FAIL              = "fail"
+	OVERWRITE_OVERWRITE         = "overwrite"
+	OVERWRITE_BACKUP            = "backup"
+	OVERWRITE_SKIP_IF_IDENTICAL = "skip-if-identical"
+)
+
+// IsOverwritePolicy checks if `policy` is a valid overwrite policy.
+func IsOverwritePolicy(policy string) bool {
+	switch policy {
+	case OVERWRITE_FAIL, OVERWRITE_OVERWRITE, OVERWRITE_BACKUP, OVERWRITE_SKIP_IF_IDENTICAL:
+		return true
+	}
+
+	return false
+}
+
+// Returns the overwrite policy for a given file, or an empty string if none is set.
+func OverwriteFor(contents []interface{}, file string) string {
+	for _, c := range contents {
+		entry := c.(map[interface{}]interface{})
+		if entry["path"].(string) == file {
+			if policy, ok := entry["overwrite"].(string); ok {
+				return policy
+			}
+			break
+		}
+	}
+
+	return ""
+}
+
+// IsConfig checks if a given file is marked as configuration file.
+func IsConfig(contents []interface{}, file string) bool {
+	for _, c := range contents {
+		entry := c.(map[interface{}]interface{})
+		if entry["path"].(string) == file {
+			config, _ := entry["config"].(bool)
+			return config
+		}
+	}
+
+	return false
+}
+
+// Returns the owner and group for a given file, as names or numeric ids.
+// Either is an empty string if it isn't set.
+func OwnerFor(contents []interface{}, file string) (string, string) {
+	for _, c := range contents {
+		entry := c.(map[interface{}]interface{})
+		if entry["path"].(string) == file {
+			var owner, group string
+			if entry["owner"] != nil {
+				owner = fmt.Sprint(entry["owner"])
+			}
+			if entry["group"] != nil {
+				group = fmt.Sprint(entry["group"])
+			}
+			return owner, group
+		}
+	}
+
+	return "", ""
+}
@@ -0,0 +1,39 @@
+package manifest_test
+
+import (
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/manifest"
+)
+
+func TestModeFor(t *testing.T) {
+	contents := []interface{}{
+		map[interface{}]interface{}{"path": "/usr/bin/tool", "mode": 0700},
+		map[interface{}]interface{}{"path": "/usr/bin/script"},
+		map[interface{}]interface{}{"path": "/etc/tool.conf", "mode": 0600},
+		map[interface{}]interface{}{"path": "/var/lib/tool"},
+	}
+
+	var tests = []struct {
+		file     string
+		fallback int // mode recorded in git
+		out      int
+	}{
+		{"/usr/bin/tool", 0755, 0700},
+		{"/usr/bin/tool", 0644, 0700},
+		{"/etc/tool.conf", 0755, 0600},
+		{"/usr/bin/script", 0755, 0755},
+		{"/usr/bin/script", 0644, 0644},
+		{"/var/lib/tool", 0, 0},
+		{"/usr/bin/other", 0755, 0755},
+		{"/usr/bin/other", 0644, 0644},
+		{"/usr/bin", 0, 0},
+	}
+
+	for i, tt := range tests {
+		mode := manifest.ModeFor(contents, tt.file, tt.fallback)
+		if mode != tt.out {
+			t.Errorf("%d. ModeFor(%q, %#o) => %#o, wanted: %#o", i, tt.file, tt.fallback, mode, tt.out)
+		}
+	}
+}
@@ -5,6 +5,22 @@ import (
 	"path/filepath"
 )
 
+// Files which are part of the package repository, but are never installed.
+var SkipFiles = [...]string{".gitattributes", ".gitignore", ".gitmodules",
+	"MANIFEST.yaml", "MANIFEST.yaml.sig", "MANIFEST.yaml.gpg", "MANIFEST.yaml.sig.d",
+	"README.md"}
+
+// IsSkipped checks if the basename `file` is never to be installed.
+func IsSkipped(file string) bool {
+	for _, skipfile := range SkipFiles {
+		if file == skipfile {
+			return true
+		}
+	}
+
+	return false
+}
+
 // IgnorePatterns() takes a Manifest and returns the array of ignore patterns
 func IgnorePatterns(manifest map[interface{}]interface{}) []interface{} {
 	var ignore_patterns []interface{}
@@ -1,19 +1,43 @@
 package manifest
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 
 	"github.com/RedCoolBeans/crane/util"
+	"golang.org/x/crypto/openpgp/clearsign"
 	"gopkg.in/yaml.v2"
 )
 
+// CLEARSIGNED starts a clearsigned manifest, see also gpg.IsClearsigned().
+const CLEARSIGNED = "-----BEGIN PGP SIGNED MESSAGE-----"
+
 func ReadFile(file string) map[interface{}]interface{} {
 	data, err := ioutil.ReadFile(file)
 	util.Check(err, true)
 
+	data, err = Body(data)
+	util.Check(err, true)
+
 	m := make(map[interface{}]interface{})
 	err = yaml.Unmarshal([]byte(data), &m)
 	util.Check(err, true)
 
 	return m
 }
+
+// Body returns the manifest contained in `data`. For a clearsigned manifest
+// that is only the signed body, anything outside of it is ignored.
+func Body(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(CLEARSIGNED)) {
+		return data, nil
+	}
+
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, errors.New("Invalid clearsigned manifest")
+	}
+
+	return block.Plaintext, nil
+}
@@ -13,3 +13,37 @@ func VersionString(manifest map[interface{}]interface{}) string {
 
 	return version
 }
+
+// Submodules returns whether the manifest requests git submodules to be
+// checked out.
+func Submodules(manifest map[interface{}]interface{}) bool {
+	if submodules, ok := manifest["submodules"].(bool); ok {
+		return submodules
+	}
+
+	return false
+}
+
+// TreeSha256 returns the tree hash recorded in the manifest, or an empty
+// string if there is none.
+func TreeSha256(manifest map[interface{}]interface{}) string {
+	if tree, ok := manifest["tree_sha256"].(string); ok {
+		return tree
+	}
+
+	return ""
+}
+
+// Replaces returns the names of the packages whose files this package is
+// allowed to take ownership of.
+func Replaces(manifest map[interface{}]interface{}) []string {
+	replaces := make([]string, 0)
+
+	if list, ok := manifest["replaces"].([]interface{}); ok {
+		for _, name := range list {
+			replaces = append(replaces, fmt.Sprint(name))
+		}
+	}
+
+	return replaces
+}
@@ -17,6 +17,10 @@ func Validate(manifest map[interface{}]interface{}) error {
 		return err
 	}
 
+	if err := ValidateAccountFields(manifest); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -34,6 +38,10 @@ func ValidateRequiredFields(manifest map[interface{}]interface{}) error {
 		return errors.New(err)
 	}
 
+	if _, err := Expires(manifest); err != nil {
+		return err
+	}
+
 	return nil
 }
 
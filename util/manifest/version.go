@@ -0,0 +1,129 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// Revision returns the revision of the package, or "" if it has none.
+func Revision(manifest map[interface{}]interface{}) string {
+	if manifest["revision"] == nil {
+		return ""
+	}
+
+	return fmt.Sprint(manifest["revision"])
+}
+
+// Version returns the version of the package.
+func Version(manifest map[interface{}]interface{}) string {
+	return fmt.Sprint(manifest["version"])
+}
+
+// CompareVersions compares the version strings `a` and `b`, returning -1, 0
+// or 1 if `a` is older than, equal to, or newer than `b`. Versions are
+// compared by alternating runs of digits (compared numerically) and other
+// characters (compared lexically), so that 1.10 is newer than 1.9. A run of
+// other characters where the other version has a number or ends is a
+// pre-release, so 2.0rc1 is older than both 2.0 and 2.0.1.
+func CompareVersions(a string, b string) int {
+	for a != "" || b != "" {
+		var ra, rb string
+		ra, a = nextRun(a)
+		rb, b = nextRun(b)
+
+		na, erra := strconv.ParseUint(ra, 10, 64)
+		nb, errb := strconv.ParseUint(rb, 10, 64)
+
+		switch {
+		case erra == nil && errb == nil:
+			if na != nb {
+				return cmp(na < nb)
+			}
+		case erra == nil && rb == "":
+			// 1.0.1 is newer than 1.0
+			return 1
+		case errb == nil && ra == "":
+			return -1
+		case ra == "":
+			// 2.0 is newer than its pre-release 2.0rc1
+			return 1
+		case rb == "":
+			return -1
+		case erra == nil:
+			// 2.0.1 is newer than 2.0rc1
+			return 1
+		case errb == nil:
+			return -1
+		default:
+			if ra != rb {
+				return cmp(ra < rb)
+			}
+		}
+	}
+
+	return 0
+}
+
+// CompareVersionRevision compares version `a` with revision `reva` to
+// version `b` with revision `revb`, see CompareVersions.
+func CompareVersionRevision(a string, reva string, b string, revb string) int {
+	if c := CompareVersions(a, b); c != 0 {
+		return c
+	}
+
+	return CompareVersions(reva, revb)
+}
+
+func cmp(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// nextRun splits off the leading run of digits or non-digits of `s`,
+// skipping separators.
+func nextRun(s string) (string, string) {
+	for len(s) > 0 && (s[0] == '.' || s[0] == '-' || s[0] == '_' || s[0] == '+') {
+		s = s[1:]
+	}
+
+	if s == "" {
+		return "", ""
+	}
+
+	digit := unicode.IsDigit(rune(s[0]))
+	i := 1
+	for i < len(s) && unicode.IsDigit(rune(s[i])) == digit && !isSeparator(s[i]) {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+func isSeparator(c byte) bool {
+	return c == '.' || c == '-' || c == '_' || c == '+'
+}
+
+// Expires returns the time after which the manifest is no longer valid, or
+// the zero time if it doesn't expire.
+func Expires(manifest map[interface{}]interface{}) (time.Time, error) {
+	switch expires := manifest["expires"].(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return expires, nil
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if t, err := time.Parse(layout, expires); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	err := fmt.Sprintf("field expires must be a date (YYYY-MM-DD) or RFC3339 timestamp, is %v", manifest["expires"])
+	return time.Time{}, errors.New(err)
+}
@@ -0,0 +1,56 @@
+package manifest_test
+
+import (
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/manifest"
+)
+
+func TestCompareVersions(t *testing.T) {
+	var tests = []struct {
+		a   string
+		b   string
+		out int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.9", "1.10", -1},
+		{"1.10", "1.9", 1},
+		{"1.0.1", "1.0", 1},
+		{"1.0", "1.0.1", -1},
+		{"2.0rc1", "2.0rc2", -1},
+		{"2.0rc1", "2.0", -1},
+		{"2.0", "2.0rc1", 1},
+		{"2.0-rc1", "2.0", -1},
+		{"2.0rc1", "2.0.1", -1},
+		{"2.0.1", "2.0rc1", 1},
+		{"2.0beta2", "2.0rc1", -1},
+		{"1.2.3", "1.2-3", 0},
+		{"", "1", -1},
+	}
+
+	for i, tt := range tests {
+		c := manifest.CompareVersions(tt.a, tt.b)
+		if c != tt.out {
+			t.Errorf("%d. %q <=> %q => %d, wanted: %d", i, tt.a, tt.b, c, tt.out)
+		}
+	}
+}
+
+func TestExpires(t *testing.T) {
+	var tests = []struct {
+		expires interface{}
+		ok      bool
+	}{
+		{nil, true},
+		{"2017-01-31", true},
+		{"2017-01-31T12:00:00Z", true},
+		{"next week", false},
+	}
+
+	for i, tt := range tests {
+		m := map[interface{}]interface{}{"expires": tt.expires}
+		if _, err := manifest.Expires(m); (err == nil) != tt.ok {
+			t.Errorf("%d. %v => %v, wanted ok: %v", i, tt.expires, err, tt.ok)
+		}
+	}
+}
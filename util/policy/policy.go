@@ -0,0 +1,132 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule binds a set of key fingerprints to packages and/or repositories.
+// Packages and repositories are matched with filepath.Match() patterns.
+type Rule struct {
+	Packages     []string `yaml:"packages"`
+	Repos        []string `yaml:"repos"`
+	Fingerprints []string `yaml:"fingerprints"`
+}
+
+// Policy is the list of rules read from a trust policy file.
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+func ReadFile(file string) (*Policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		e := fmt.Sprintf("Could not read trust policy %s: %s", file, err)
+		return nil, errors.New(e)
+	}
+
+	p := &Policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		e := fmt.Sprintf("Invalid trust policy %s: %s", file, err)
+		return nil, errors.New(e)
+	}
+
+	for i, rule := range p.Rules {
+		if len(rule.Fingerprints) == 0 {
+			e := fmt.Sprintf("Invalid trust policy %s: rule #%d has no fingerprints", file, i+1)
+			return nil, errors.New(e)
+		}
+
+		if len(rule.Packages) == 0 && len(rule.Repos) == 0 {
+			e := fmt.Sprintf("Invalid trust policy %s: rule #%d matches no packages or repos", file, i+1)
+			return nil, errors.New(e)
+		}
+	}
+
+	return p, nil
+}
+
+// Allowed returns the fingerprints allowed to sign package `name` from
+// `repository`: those listed by every rule which matches either, so a rule
+// for a package can't be widened by a rule for a repository or vice versa.
+// It returns nil if no rule matches.
+func (p *Policy) Allowed(name string, repository string) []string {
+	var allowed []string
+
+	for _, rule := range p.Rules {
+		if !matchAny(rule.Packages, name) && !matchAny(rule.Repos, repository) {
+			continue
+		}
+
+		fprs := make([]string, 0)
+		for _, fpr := range rule.Fingerprints {
+			if allowed == nil || contains(allowed, Normalize(fpr)) {
+				fprs = append(fprs, Normalize(fpr))
+			}
+		}
+		allowed = fprs
+	}
+
+	return allowed
+}
+
+// Check returns an error unless one of `fingerprints` may sign package
+// `name` from `repository`.
+func (p *Policy) Check(name string, repository string, fingerprints []string) error {
+	allowed := p.Allowed(name, repository)
+	if allowed == nil {
+		e := fmt.Sprintf("No trust policy rule for %s (%s)", name, repository)
+		return errors.New(e)
+	}
+
+	for _, fpr := range fingerprints {
+		if contains(allowed, Normalize(fpr)) {
+			return nil
+		}
+	}
+
+	e := fmt.Sprintf("Key %s is not allowed to sign %s (%s) by the trust policy",
+		strings.Join(fingerprints, "/"), name, repository)
+	return errors.New(e)
+}
+
+// Normalize strips spaces and uppercases a GPG fingerprint so it can be
+// compared regardless of how it was written down. SSH fingerprints
+// (SHA256:...) are base64 and thus case sensitive.
+func Normalize(fpr string) string {
+	fpr = strings.Replace(fpr, " ", "", -1)
+	if strings.HasPrefix(fpr, "SHA256:") {
+		return fpr
+	}
+
+	return strings.ToUpper(fpr)
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if pattern == s {
+			return true
+		}
+
+		if matched, err := filepath.Match(pattern, s); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
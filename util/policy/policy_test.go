@@ -0,0 +1,45 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/policy"
+)
+
+func TestCheck(t *testing.T) {
+	p := &policy.Policy{
+		Rules: []policy.Rule{
+			{Repos: []string{"ssh://git@git.redcoolbeans.com:software/*"}, Fingerprints: []string{"AAAA 1111"}},
+			{Packages: []string{"nodejs"}, Fingerprints: []string{"bbbb2222"}},
+			{Packages: []string{"dockerlint"}, Fingerprints: []string{"AAAA1111", "CCCC3333"}},
+			{Repos: []string{"https://git.cargos.io/*"}, Fingerprints: []string{"DDDD4444", "BBBB2222"}},
+		},
+	}
+
+	var tests = []struct {
+		cargo       string
+		repository  string
+		fingerprint string
+		ok          bool
+	}{
+		{"dockerlint", "ssh://git@git.redcoolbeans.com:software/dockerlint", "AAAA1111", true},
+		{"dockerlint", "ssh://git@git.redcoolbeans.com:software/dockerlint", "BBBB2222", false},
+		{"nodejs", "https://git.cargos.io/nodejs", "BBBB2222", true},
+		{"nodejs", "https://git.cargos.io/nodejs", "AAAA1111", false},
+		{"other", "https://git.cargos.io/other", "AAAA1111", false},
+		{"other", "https://git.cargos.io/other", "DDDD4444", true},
+		{"unknown", "https://example.com/unknown", "AAAA1111", false},
+		// Every matching rule has to allow the key.
+		{"dockerlint", "ssh://git@git.redcoolbeans.com:software/dockerlint", "CCCC3333", false},
+		{"dockerlint", "https://git.cargos.io/dockerlint", "AAAA1111", false},
+		{"dockerlint", "https://git.cargos.io/dockerlint", "DDDD4444", false},
+		{"nodejs", "https://git.cargos.io/nodejs", "DDDD4444", false},
+	}
+
+	for i, tt := range tests {
+		err := p.Check(tt.cargo, tt.repository, []string{tt.fingerprint})
+		if (err == nil) != tt.ok {
+			t.Errorf("%d. %q/%q signed by %q => %v, wanted ok: %v", i, tt.cargo, tt.repository, tt.fingerprint, err, tt.ok)
+		}
+	}
+}
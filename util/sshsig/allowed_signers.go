@@ -0,0 +1,194 @@
+package sshsig
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AllowedSigner is a single entry of an allowed_signers file, see the
+// ALLOWED SIGNERS section of ssh-keygen(1).
+type AllowedSigner struct {
+	Principals  []string
+	Namespaces  []string
+	ValidAfter  time.Time
+	ValidBefore time.Time
+	Key         ssh.PublicKey
+}
+
+// Allows reports whether this entry permits `key` to sign in `namespace`
+// at time `now`.
+func (a AllowedSigner) Allows(key ssh.PublicKey, namespace string, now time.Time) bool {
+	if !bytes.Equal(a.Key.Marshal(), key.Marshal()) {
+		return false
+	}
+
+	if !a.ValidAfter.IsZero() && now.Before(a.ValidAfter) {
+		return false
+	}
+
+	if !a.ValidBefore.IsZero() && now.After(a.ValidBefore) {
+		return false
+	}
+
+	if len(a.Namespaces) == 0 {
+		return true
+	}
+
+	for _, ns := range a.Namespaces {
+		if matched, err := path.Match(ns, namespace); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReadAllowedSigners parses an allowed_signers file.
+func ReadAllowedSigners(file string) ([]AllowedSigner, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		e := fmt.Sprintf("Could not open allowed signers %s: %s", file, err)
+		return nil, errors.New(e)
+	}
+	defer f.Close()
+
+	signers := make([]AllowedSigner, 0)
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		signer, err := parseAllowedSigner(line)
+		if err != nil {
+			e := fmt.Sprintf("%s:%d: %s", file, lineno, err)
+			return nil, errors.New(e)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return signers, nil
+}
+
+func parseAllowedSigner(line string) (AllowedSigner, error) {
+	signer := AllowedSigner{}
+
+	principals, rest := nextField(line)
+	signer.Principals = strings.Split(principals, ",")
+
+	// Options are optional, if the next field is a key type there are none.
+	field, keyrest := nextField(rest)
+	if !isKeyType(field) {
+		for _, option := range splitOptions(field) {
+			name, value := option, ""
+			if i := strings.Index(option, "="); i >= 0 {
+				name, value = option[:i], strings.Trim(option[i+1:], `"`)
+			}
+
+			switch strings.ToLower(name) {
+			case "namespaces":
+				signer.Namespaces = strings.Split(value, ",")
+			case "valid-after":
+				t, err := parseTime(value)
+				if err != nil {
+					return signer, err
+				}
+				signer.ValidAfter = t
+			case "valid-before":
+				t, err := parseTime(value)
+				if err != nil {
+					return signer, err
+				}
+				signer.ValidBefore = t
+			case "cert-authority":
+				return signer, errors.New("cert-authority is not supported")
+			}
+		}
+		rest = keyrest
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(rest))
+	if err != nil {
+		return signer, err
+	}
+	signer.Key = key
+
+	return signer, nil
+}
+
+// nextField splits off the first whitespace separated field of `s`, keeping
+// quoted strings together.
+func nextField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	quoted := false
+
+	for i, c := range s {
+		if c == '"' {
+			quoted = !quoted
+		} else if (c == ' ' || c == '\t') && !quoted {
+			return s[:i], strings.TrimSpace(s[i:])
+		}
+	}
+
+	return s, ""
+}
+
+// splitOptions splits a comma separated option list, keeping quoted values
+// together.
+func splitOptions(s string) []string {
+	options := make([]string, 0)
+	quoted := false
+	start := 0
+
+	for i, c := range s {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ',' && !quoted {
+			options = append(options, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(options, s[start:])
+}
+
+func isKeyType(s string) bool {
+	switch s {
+	case ssh.KeyAlgoRSA, ssh.KeyAlgoDSA, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521, ssh.KeyAlgoED25519:
+		return true
+	}
+
+	return false
+}
+
+// parseTime parses the YYYYMMDD[HHMM[SS]] timestamps used by ssh-keygen.
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSuffix(s, "Z")
+
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(s) == len(layout) {
+			return time.Parse(layout, s)
+		}
+	}
+
+	e := fmt.Sprintf("invalid time %q", s)
+	return time.Time{}, errors.New(e)
+}
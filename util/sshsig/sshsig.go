@@ -0,0 +1,180 @@
+// Package sshsig verifies signatures made with `ssh-keygen -Y sign`, as
+// described in OpenSSH's PROTOCOL.sshsig.
+package sshsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	MAGIC       = "SSHSIG"
+	VERSION     = 1
+	ARMOR_BEGIN = "-----BEGIN SSH SIGNATURE-----"
+	ARMOR_END   = "-----END SSH SIGNATURE-----"
+)
+
+// Signature is a parsed SSH signature.
+type Signature struct {
+	PublicKey ssh.PublicKey
+	Namespace string
+	HashAlgo  string
+	Signature *ssh.Signature
+}
+
+type wireSignature struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlgo  string
+	Signature []byte
+}
+
+type wireSig struct {
+	Format string
+	Blob   []byte
+	Rest   []byte `ssh:"rest"`
+}
+
+type wireRSAKey struct {
+	Name string
+	E    *big.Int
+	N    *big.Int
+	Rest []byte `ssh:"rest"`
+}
+
+// IsArmored reports whether `data` starts with an SSH signature armor header.
+func IsArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(ARMOR_BEGIN))
+}
+
+// Parse decodes an armored SSH signature.
+func Parse(armored []byte) (*Signature, error) {
+	text := strings.TrimSpace(string(armored))
+	if !strings.HasPrefix(text, ARMOR_BEGIN) || !strings.HasSuffix(text, ARMOR_END) {
+		return nil, errors.New("not an armored SSH signature")
+	}
+
+	text = strings.TrimSuffix(strings.TrimPrefix(text, ARMOR_BEGIN), ARMOR_END)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(blob, []byte(MAGIC)) {
+		return nil, errors.New("invalid SSH signature magic")
+	}
+
+	var w wireSignature
+	if err := ssh.Unmarshal(blob[len(MAGIC):], &w); err != nil {
+		return nil, err
+	}
+
+	if w.Version != VERSION {
+		e := fmt.Sprintf("unsupported SSH signature version %d", w.Version)
+		return nil, errors.New(e)
+	}
+
+	key, err := ssh.ParsePublicKey(w.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig wireSig
+	if err := ssh.Unmarshal(w.Signature, &sig); err != nil {
+		return nil, err
+	}
+
+	return &Signature{
+		PublicKey: key,
+		Namespace: w.Namespace,
+		HashAlgo:  w.HashAlgo,
+		Signature: &ssh.Signature{Format: sig.Format, Blob: sig.Blob},
+	}, nil
+}
+
+// Verify checks the signature over `message` in the given `namespace`.
+func (s *Signature) Verify(message []byte, namespace string) error {
+	if s.Namespace != namespace {
+		e := fmt.Sprintf("signature namespace %q, wanted: %q", s.Namespace, namespace)
+		return errors.New(e)
+	}
+
+	var h hash.Hash
+	switch s.HashAlgo {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		e := fmt.Sprintf("unsupported hash algorithm %q", s.HashAlgo)
+		return errors.New(e)
+	}
+	h.Write(message)
+
+	var signed bytes.Buffer
+	signed.WriteString(MAGIC)
+	writeString(&signed, []byte(s.Namespace))
+	writeString(&signed, []byte{})
+	writeString(&signed, []byte(s.HashAlgo))
+	writeString(&signed, h.Sum(nil))
+
+	// The vendored ssh package only knows about SHA1 RSA signatures, while
+	// SSHSIG requires rsa-sha2-256 or rsa-sha2-512.
+	if s.PublicKey.Type() == ssh.KeyAlgoRSA {
+		return verifyRSA(s.PublicKey, signed.Bytes(), s.Signature)
+	}
+
+	return s.PublicKey.Verify(signed.Bytes(), s.Signature)
+}
+
+func verifyRSA(key ssh.PublicKey, data []byte, sig *ssh.Signature) error {
+	var w wireRSAKey
+	if err := ssh.Unmarshal(key.Marshal(), &w); err != nil {
+		return err
+	}
+
+	var algo crypto.Hash
+	switch sig.Format {
+	case "rsa-sha2-256":
+		algo = crypto.SHA256
+	case "rsa-sha2-512":
+		algo = crypto.SHA512
+	default:
+		e := fmt.Sprintf("unsupported RSA signature format %q", sig.Format)
+		return errors.New(e)
+	}
+
+	h := algo.New()
+	h.Write(data)
+
+	pub := &rsa.PublicKey{N: w.N, E: int(w.E.Int64())}
+	return rsa.VerifyPKCS1v15(pub, algo, h.Sum(nil), sig.Blob)
+}
+
+func writeString(buf *bytes.Buffer, s []byte) {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(s)))
+	buf.Write(l[:])
+	buf.Write(s)
+}
+
+// Fingerprint returns the SHA256 fingerprint of `key` the way ssh-keygen
+// prints it.
+func Fingerprint(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "=")
+}
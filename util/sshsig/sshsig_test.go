@@ -0,0 +1,36 @@
+package sshsig_test
+
+import (
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/sshsig"
+)
+
+func TestVerifyAll(t *testing.T) {
+	var tests = []struct {
+		sig       string
+		namespace string
+		principal string
+	}{
+		{"alice.sig", "crane", "alice@example.com"}, // ed25519
+		{"bob.sig", "crane", "bob@example.com"},     // rsa-sha2-512
+		{"alice-git.sig", "crane", ""},              // signed in the wrong namespace
+		{"alice.sig", "git", ""},                    // namespace not allowed for alice
+		{"nonexistent.sig", "crane", ""},
+	}
+
+	for i, tt := range tests {
+		signers := sshsig.VerifyAll("testdata/allowed_signers", tt.namespace, tt.sig, "testdata", false)
+
+		if tt.principal == "" {
+			if len(signers) != 0 {
+				t.Errorf("%d. %s (%s) => %v, wanted no signers", i, tt.sig, tt.namespace, signers)
+			}
+			continue
+		}
+
+		if len(signers) != 1 || signers[0].Principals[0] != tt.principal {
+			t.Errorf("%d. %s (%s) => %v, wanted: %q", i, tt.sig, tt.namespace, signers, tt.principal)
+		}
+	}
+}
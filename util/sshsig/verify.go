@@ -0,0 +1,98 @@
+package sshsig
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/RedCoolBeans/crane/util/logging"
+	m "github.com/RedCoolBeans/crane/util/manifest"
+)
+
+// Signer describes the key that made a valid signature.
+type Signer struct {
+	Principals  []string
+	Fingerprint string
+}
+
+// VerifyAll checks every SSH signature of the MANIFEST.yaml in `clonedir`
+// in `signaturePath` and the `signaturePath`.d/ directory against the
+// allowed signers. It returns the distinct keys that made a valid signature,
+// invalid signatures are ignored.
+func VerifyAll(allowedSignersPath string, namespace string, signaturePath string, clonedir string, verbose bool) []Signer {
+	signers := make([]Signer, 0)
+
+	allowed, err := ReadAllowedSigners(allowedSignersPath)
+	if err != nil {
+		logging.PrError("%s", err)
+	}
+
+	manifest, err := ioutil.ReadFile(path.Join(clonedir, m.MANIFEST))
+	if err != nil {
+		logging.PrError("Could not open %s: %v", m.MANIFEST, err)
+	}
+
+	sigpath := path.Join(clonedir, signaturePath)
+	files := []string{sigpath}
+	if entries, err := ioutil.ReadDir(sigpath + m.SIG_DIRSUFFIX); err == nil {
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				files = append(files, path.Join(sigpath+m.SIG_DIRSUFFIX, entry.Name()))
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logging.PrVerbose(verbose, "Ignoring signature %s: %s", file, err)
+			}
+			continue
+		}
+
+		if !IsArmored(data) {
+			logging.PrVerbose(verbose, "Ignoring %s: not an SSH signature", file)
+			continue
+		}
+
+		sig, err := Parse(data)
+		if err != nil {
+			logging.PrVerbose(verbose, "Ignoring invalid signature %s: %s", file, err)
+			continue
+		}
+
+		if err := sig.Verify(manifest, namespace); err != nil {
+			logging.PrVerbose(verbose, "Ignoring invalid signature %s: %s", file, err)
+			continue
+		}
+
+		signer := Signer{Fingerprint: Fingerprint(sig.PublicKey)}
+		for _, a := range allowed {
+			if a.Allows(sig.PublicKey, namespace, now) {
+				signer.Principals = append(signer.Principals, a.Principals...)
+			}
+		}
+
+		if len(signer.Principals) == 0 {
+			logging.PrVerbose(verbose, "Ignoring signature %s: key %s is not an allowed signer", file, signer.Fingerprint)
+			continue
+		}
+
+		duplicate := false
+		for _, s := range signers {
+			if s.Fingerprint == signer.Fingerprint {
+				duplicate = true
+			}
+		}
+
+		if !duplicate {
+			signers = append(signers, signer)
+		}
+	}
+
+	return signers
+}
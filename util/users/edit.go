@@ -0,0 +1,309 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// Ranges ids are allocated from, if a user or group doesn't ask for one.
+var (
+	SystemRange = Range{100, 999}
+	UserRange   = Range{1000, 59999}
+)
+
+// Range is an inclusive range of uids or gids.
+type Range struct {
+	Min int
+	Max int
+}
+
+// Default modes of files which don't exist yet.
+var fileModes = map[string]os.FileMode{
+	PASSWD: 0644,
+	GROUP:  0644,
+	SHADOW: 0640,
+}
+
+// Validate checks that `u` can be written to etc/passwd and etc/shadow.
+func (u User) Validate() error {
+	if err := checkField("user", u.Name, "name", u.Name, ":,"); err != nil {
+		return err
+	}
+
+	for _, f := range [][2]string{{"gecos", u.Gecos}, {"home", u.Home}, {"shell", u.Shell}} {
+		if err := checkField("user", u.Name, f[0], f[1], ":"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that `g` can be written to etc/group.
+func (g Group) Validate() error {
+	if err := checkField("group", g.Name, "name", g.Name, ":,"); err != nil {
+		return err
+	}
+
+	for _, member := range g.Members {
+		if err := checkField("group", g.Name, "member", member, ":,"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkField makes sure `value` can't be used to add fields or entries to the
+// colon separated files: it may not contain any of `separators`, nor control
+// characters such as newlines. Names may not be empty.
+func checkField(kind string, name string, field string, value string, separators string) error {
+	if field == "name" && value == "" {
+		e := fmt.Sprintf("Invalid %s, its name is empty", kind)
+		return errors.New(e)
+	}
+
+	for _, c := range value {
+		if unicode.IsControl(c) || strings.ContainsRune(separators, c) {
+			e := fmt.Sprintf("Invalid %s of %s %q, it may not contain %q", field, kind, name, c)
+			return errors.New(e)
+		}
+	}
+
+	return nil
+}
+
+// AddGroup creates `group`, or checks that an existing group with the same
+// name matches it. A gid of -1 is allocated from `ids`. Members missing from
+// an existing group are added. Returns the group as it's recorded.
+func (d *Database) AddGroup(group Group, ids Range) (Group, error) {
+	if err := group.Validate(); err != nil {
+		return group, err
+	}
+
+	if existing, ok := d.LookupGroup(group.Name); ok {
+		if group.Gid != -1 && group.Gid != existing.Gid {
+			e := fmt.Sprintf("Group %s already exists with gid %d instead of %d", group.Name, existing.Gid, group.Gid)
+			return existing, errors.New(e)
+		}
+
+		for _, member := range group.Members {
+			d.AddMember(group.Name, member)
+		}
+
+		existing, _ = d.LookupGroup(group.Name)
+		return existing, nil
+	}
+
+	if group.Gid == -1 {
+		gid, err := allocate(ids, d.GidUsed)
+		if err != nil {
+			e := fmt.Sprintf("Could not allocate a gid for group %s: %s", group.Name, err)
+			return group, errors.New(e)
+		}
+		group.Gid = gid
+	} else if d.GidUsed(group.Gid) {
+		e := fmt.Sprintf("Could not create group %s, gid %d is already in use", group.Name, group.Gid)
+		return group, errors.New(e)
+	}
+
+	if group.Members == nil {
+		group.Members = make([]string, 0)
+	}
+
+	d.Groups = append(d.Groups, group)
+	d.append(GROUP, fmt.Sprintf("%s:x:%d:%s", group.Name, group.Gid, strings.Join(group.Members, ",")))
+
+	return group, nil
+}
+
+// AddUser creates `user`, or checks that an existing user with the same name
+// matches its uid and primary gid (unless they're -1). A uid of -1 is
+// allocated from `ids`, preferring the same id as the primary group. Existing
+// users aren't modified otherwise. Returns the user as it's recorded.
+func (d *Database) AddUser(user User, ids Range) (User, error) {
+	if err := user.Validate(); err != nil {
+		return user, err
+	}
+
+	if existing, ok := d.LookupUser(user.Name); ok {
+		if user.Uid != -1 && user.Uid != existing.Uid {
+			e := fmt.Sprintf("User %s already exists with uid %d instead of %d", user.Name, existing.Uid, user.Uid)
+			return existing, errors.New(e)
+		}
+
+		if user.Gid != -1 && user.Gid != existing.Gid {
+			e := fmt.Sprintf("User %s already exists with primary gid %d instead of %d", user.Name, existing.Gid, user.Gid)
+			return existing, errors.New(e)
+		}
+
+		return existing, nil
+	}
+
+	if user.Uid == -1 {
+		if user.Gid >= ids.Min && user.Gid <= ids.Max && !d.UidUsed(user.Gid) {
+			user.Uid = user.Gid
+		} else {
+			uid, err := allocate(ids, d.UidUsed)
+			if err != nil {
+				e := fmt.Sprintf("Could not allocate a uid for user %s: %s", user.Name, err)
+				return user, errors.New(e)
+			}
+			user.Uid = uid
+		}
+	} else if d.UidUsed(user.Uid) {
+		e := fmt.Sprintf("Could not create user %s, uid %d is already in use", user.Name, user.Uid)
+		return user, errors.New(e)
+	}
+
+	// etc/shadow is only read when it's needed, it's usually only readable by root.
+	if _, ok := d.lines[SHADOW]; !ok {
+		d.lines[SHADOW] = make([]string, 0)
+		if err := d.readEntries(SHADOW, 9, func(fields []string) error { return nil }); err != nil {
+			return user, err
+		}
+	}
+
+	d.Users = append(d.Users, user)
+	d.append(PASSWD, fmt.Sprintf("%s:x:%d:%d:%s:%s:%s", user.Name, user.Uid, user.Gid, user.Gecos, user.Home, user.Shell))
+
+	// The account is locked, it's meant for services rather than logins.
+	if !d.hasShadow(user.Name) {
+		days := time.Now().Unix() / 86400
+		d.append(SHADOW, fmt.Sprintf("%s:!:%d:0:99999:7:::", user.Name, days))
+	}
+
+	return user, nil
+}
+
+// AddMember adds `user` to the existing group `name`, unless it's a member.
+func (d *Database) AddMember(name string, user string) error {
+	if err := checkField("group", name, "member", user, ":,"); err != nil {
+		return err
+	}
+
+	for i, g := range d.Groups {
+		if g.Name != name {
+			continue
+		}
+
+		for _, member := range g.Members {
+			if member == user {
+				return nil
+			}
+		}
+
+		d.Groups[i].Members = append(g.Members, user)
+		for j, line := range d.lines[GROUP] {
+			if strings.HasPrefix(line, name+":") {
+				fields := strings.Split(line, ":")
+				fields[3] = strings.Join(d.Groups[i].Members, ",")
+				d.lines[GROUP][j] = strings.Join(fields, ":")
+				d.dirty[GROUP] = true
+			}
+		}
+
+		return nil
+	}
+
+	e := fmt.Sprintf("Could not add %s to group %s, it doesn't exist", user, name)
+	return errors.New(e)
+}
+
+// Changed checks if any users or groups were added or modified.
+func (d *Database) Changed() bool {
+	return len(d.dirty) > 0
+}
+
+// Write writes the modified files into `dir` (i.e. a staging directory for
+// Root), keeping the mode and owner of the existing files.
+func (d *Database) Write(dir string) error {
+	for name := range d.dirty {
+		file := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			e := fmt.Sprintf("Could not create %s: %s", path.Dir(file), err)
+			return errors.New(e)
+		}
+
+		mode := fileModes[name]
+		uid, gid := -1, -1
+		if info, err := os.Stat(path.Join(d.Root, name)); err == nil {
+			mode = info.Mode().Perm()
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				uid, gid = int(stat.Uid), int(stat.Gid)
+			}
+		}
+
+		data := strings.Join(d.lines[name], "\n") + "\n"
+		if err := ioutil.WriteFile(file, []byte(data), mode); err != nil {
+			e := fmt.Sprintf("Could not write %s: %s", file, err)
+			return errors.New(e)
+		}
+
+		// WriteFile is subject to the umask.
+		if err := os.Chmod(file, mode); err != nil {
+			return err
+		}
+		if err := os.Lchown(file, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Database) append(name string, line string) {
+	d.lines[name] = append(d.lines[name], line)
+	d.dirty[name] = true
+}
+
+// UidUsed checks if a user with `uid` exists.
+func (d *Database) UidUsed(uid int) bool {
+	for _, u := range d.Users {
+		if u.Uid == uid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GidUsed checks if a group with `gid` exists.
+func (d *Database) GidUsed(gid int) bool {
+	for _, g := range d.Groups {
+		if g.Gid == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Database) hasShadow(name string) bool {
+	for _, line := range d.lines[SHADOW] {
+		if strings.HasPrefix(line, name+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allocate returns the lowest id in `ids` which isn't `used`.
+func allocate(ids Range, used func(int) bool) (int, error) {
+	for id := ids.Min; id <= ids.Max; id++ {
+		if !used(id) {
+			return id, nil
+		}
+	}
+
+	e := fmt.Sprintf("no free id in %d-%d", ids.Min, ids.Max)
+	return -1, errors.New(e)
+}
@@ -0,0 +1,211 @@
+package users
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	PASSWD = "etc/passwd"
+	GROUP  = "etc/group"
+	SHADOW = "etc/shadow"
+)
+
+// User is an entry of etc/passwd.
+type User struct {
+	Name  string
+	Uid   int
+	Gid   int
+	Gecos string
+	Home  string
+	Shell string
+}
+
+// Group is an entry of etc/group.
+type Group struct {
+	Name    string
+	Gid     int
+	Members []string
+}
+
+// Database holds the users and groups of a root filesystem, so names can be
+// resolved against the destination rather than the host Crane runs on.
+type Database struct {
+	Root   string
+	Users  []User
+	Groups []Group
+
+	lines map[string][]string // Lines of every file, as they're to be written
+	dirty map[string]bool
+}
+
+// Load reads etc/passwd and etc/group below `root`. Missing files are
+// treated as empty.
+func Load(root string) (*Database, error) {
+	d := &Database{
+		Root:  root,
+		lines: make(map[string][]string),
+		dirty: make(map[string]bool),
+	}
+
+	err := d.readEntries(PASSWD, 7, func(fields []string) error {
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return err
+		}
+
+		d.Users = append(d.Users, User{fields[0], uid, gid, fields[4], fields[5], fields[6]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.readEntries(GROUP, 4, func(fields []string) error {
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+
+		members := make([]string, 0)
+		if fields[3] != "" {
+			members = strings.Split(fields[3], ",")
+		}
+
+		d.Groups = append(d.Groups, Group{fields[0], gid, members})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// readEntries calls `parse` for every line of the colon separated `name`,
+// which must have `n` fields. Empty lines and comments are skipped.
+func (d *Database) readEntries(name string, n int, parse func([]string) error) error {
+	file := path.Join(d.Root, name)
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		e := fmt.Sprintf("Could not read %s: %s", file, err)
+		return errors.New(e)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		d.lines[name] = append(d.lines[name], line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != n {
+			e := fmt.Sprintf("Invalid entry in %s on line %d: %s", file, lineno, line)
+			return errors.New(e)
+		}
+
+		if err := parse(fields); err != nil {
+			e := fmt.Sprintf("Invalid entry in %s on line %d: %s", file, lineno, err)
+			return errors.New(e)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// LookupUser returns the user called `name`.
+func (d *Database) LookupUser(name string) (User, bool) {
+	for _, u := range d.Users {
+		if u.Name == name {
+			return u, true
+		}
+	}
+
+	return User{}, false
+}
+
+// LookupGroup returns the group called `name`.
+func (d *Database) LookupGroup(name string) (Group, bool) {
+	for _, g := range d.Groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+
+	return Group{}, false
+}
+
+// Uid resolves `owner`, which is either numeric or a user name.
+func (d *Database) Uid(owner string) (int, error) {
+	if uid, err := strconv.Atoi(owner); err == nil && uid >= 0 {
+		return uid, nil
+	}
+
+	if u, ok := d.LookupUser(owner); ok {
+		return u.Uid, nil
+	}
+
+	e := fmt.Sprintf("Unknown user %s in %s", owner, path.Join(d.Root, PASSWD))
+	return -1, errors.New(e)
+}
+
+// Gid resolves `group`, which is either numeric or a group name.
+func (d *Database) Gid(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil && gid >= 0 {
+		return gid, nil
+	}
+
+	if g, ok := d.LookupGroup(group); ok {
+		return g.Gid, nil
+	}
+
+	e := fmt.Sprintf("Unknown group %s in %s", group, path.Join(d.Root, GROUP))
+	return -1, errors.New(e)
+}
+
+// Resolve returns the uid and gid for `owner` and `group`. An empty group
+// defaults to the primary group of the owner if it's a known user. Either
+// is -1 if it's empty (or the default group isn't known), i.e. unchanged.
+func (d *Database) Resolve(owner string, group string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if owner != "" {
+		var err error
+		if uid, err = d.Uid(owner); err != nil {
+			return -1, -1, err
+		}
+
+		if group == "" {
+			for _, u := range d.Users {
+				if u.Name == owner || u.Uid == uid {
+					gid = u.Gid
+					break
+				}
+			}
+		}
+	}
+
+	if group != "" {
+		var err error
+		if gid, err = d.Gid(group); err != nil {
+			return -1, -1, err
+		}
+	}
+
+	return uid, gid, nil
+}
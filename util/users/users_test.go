@@ -0,0 +1,188 @@
+package users_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/RedCoolBeans/crane/util/users"
+)
+
+func TestResolve(t *testing.T) {
+	root, err := ioutil.TempDir("", "crane-users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(path.Join(root, "etc"), 0755)
+	ioutil.WriteFile(path.Join(root, users.PASSWD), []byte(
+		"root:x:0:0:root:/root:/bin/sh\n"+
+			"# service accounts\n"+
+			"www:x:80:82:Web server:/var/www:/sbin/nologin\n"), 0644)
+	ioutil.WriteFile(path.Join(root, users.GROUP), []byte(
+		"root:x:0:\n"+
+			"www-data:x:82:www\n"+
+			"staff:x:50:root,www\n"), 0644)
+
+	d, err := users.Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		owner string
+		group string
+		uid   int
+		gid   int
+		ok    bool
+	}{
+		{"", "", -1, -1, true},
+		{"www", "", 80, 82, true},
+		{"www", "staff", 80, 50, true},
+		{"80", "", 80, 82, true},
+		{"1000", "", 1000, -1, true},
+		{"1000", "1000", 1000, 1000, true},
+		{"", "www-data", -1, 82, true},
+		{"nobody", "", -1, -1, false},
+		{"www", "nogroup", -1, -1, false},
+	}
+
+	for i, tt := range tests {
+		uid, gid, err := d.Resolve(tt.owner, tt.group)
+		if (err == nil) != tt.ok {
+			t.Errorf("%d. %q:%q error => %v, wanted ok: %v", i, tt.owner, tt.group, err, tt.ok)
+		}
+		if uid != tt.uid || gid != tt.gid {
+			t.Errorf("%d. %q:%q => %d:%d, wanted: %d:%d", i, tt.owner, tt.group, uid, gid, tt.uid, tt.gid)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	root, err := ioutil.TempDir("", "crane-users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(path.Join(root, "etc"), 0755)
+	ioutil.WriteFile(path.Join(root, users.PASSWD), []byte(
+		"root:x:0:0:root:/root:/bin/sh\n"+
+			"www:x:100:100::/var/www:/sbin/nologin\n"), 0644)
+	ioutil.WriteFile(path.Join(root, users.GROUP), []byte(
+		"root:x:0:\n"+
+			"www:x:100:\n"+
+			"staff:x:50:root\n"), 0644)
+	ioutil.WriteFile(path.Join(root, users.SHADOW), []byte(
+		"root:*:17000:0:99999:7:::\n"+
+			"www:!:17000:0:99999:7:::\n"), 0640)
+
+	d, err := users.Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groups = []struct {
+		group users.Group
+		gid   int
+		ok    bool
+	}{
+		{users.Group{Name: "www", Gid: -1}, 100, true},
+		{users.Group{Name: "www", Gid: 100}, 100, true},
+		{users.Group{Name: "www", Gid: 101}, 100, false},
+		{users.Group{Name: "db", Gid: -1}, 101, true},
+		{users.Group{Name: "cache", Gid: 50}, 50, false},
+		{users.Group{Name: "cache", Gid: 500}, 500, true},
+		{users.Group{Name: "staff", Gid: -1, Members: []string{"db", "root"}}, 50, true},
+		{users.Group{Name: "", Gid: -1}, -1, false},
+		{users.Group{Name: "wheel:x:0:", Gid: -1}, -1, false},
+		{users.Group{Name: "staff", Gid: -1, Members: []string{"db,root"}}, 50, false},
+		{users.Group{Name: "staff", Gid: -1, Members: []string{"db\nwheel:x:0:db"}}, 50, false},
+	}
+
+	for i, tt := range groups {
+		g, err := d.AddGroup(tt.group, users.SystemRange)
+		if (err == nil) != tt.ok {
+			t.Errorf("%d. group %s error => %v, wanted ok: %v", i, tt.group.Name, err, tt.ok)
+		}
+		if err == nil && g.Gid != tt.gid {
+			t.Errorf("%d. group %s => gid %d, wanted: %d", i, tt.group.Name, g.Gid, tt.gid)
+		}
+	}
+
+	var accounts = []struct {
+		user users.User
+		uid  int
+		ok   bool
+	}{
+		{users.User{Name: "www", Uid: -1, Gid: 100}, 100, true},
+		{users.User{Name: "www", Uid: 101, Gid: 100}, 100, false},
+		{users.User{Name: "www", Uid: -1, Gid: 50}, 100, false},
+		{users.User{Name: "db", Uid: -1, Gid: 101, Home: "/var/db", Shell: "/sbin/nologin"}, 101, true},
+		{users.User{Name: "cache", Uid: 0, Gid: 500}, 0, false},
+		{users.User{Name: "cache", Uid: -1, Gid: 500}, 500, true},
+		{users.User{Name: "evil", Uid: -1, Gid: 500, Gecos: "Evil\ntoor:x:0:0::/root:/bin/sh"}, -1, false},
+		{users.User{Name: "evil", Uid: -1, Gid: 500, Gecos: "Evil:0"}, -1, false},
+		{users.User{Name: "evil", Uid: -1, Gid: 500, Home: "/\rtoor::0:0:::"}, -1, false},
+		{users.User{Name: "evil", Uid: -1, Gid: 500, Shell: "/bin/sh\x00"}, -1, false},
+		{users.User{Name: "ev,il", Uid: -1, Gid: 500}, -1, false},
+		{users.User{Name: "gecos", Uid: -1, Gid: 500, Gecos: "Full Name,Room,Phone"}, 102, true},
+	}
+
+	for i, tt := range accounts {
+		u, err := d.AddUser(tt.user, users.SystemRange)
+		if (err == nil) != tt.ok {
+			t.Errorf("%d. user %s error => %v, wanted ok: %v", i, tt.user.Name, err, tt.ok)
+		}
+		if err == nil && u.Uid != tt.uid {
+			t.Errorf("%d. user %s => uid %d, wanted: %d", i, tt.user.Name, u.Uid, tt.uid)
+		}
+	}
+
+	if err := d.Write(root); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding the same users and groups again doesn't change anything.
+	d, err = users.Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddGroup(users.Group{Name: "staff", Gid: 50, Members: []string{"db"}}, users.SystemRange)
+	d.AddUser(users.User{Name: "db", Uid: 101, Gid: 101}, users.SystemRange)
+	if d.Changed() {
+		t.Errorf("adding existing users and groups changed the database")
+	}
+
+	var files = []struct {
+		file string
+		data string
+	}{
+		{users.PASSWD, "root:x:0:0:root:/root:/bin/sh\n" +
+			"www:x:100:100::/var/www:/sbin/nologin\n" +
+			"db:x:101:101::/var/db:/sbin/nologin\n" +
+			"cache:x:500:500:::\n" +
+			"gecos:x:102:500:Full Name,Room,Phone::\n"},
+		{users.GROUP, "root:x:0:\n" +
+			"www:x:100:\n" +
+			"staff:x:50:root,db\n" +
+			"db:x:101:\n" +
+			"cache:x:500:\n"},
+	}
+
+	for i, tt := range files {
+		if data, _ := ioutil.ReadFile(path.Join(root, tt.file)); string(data) != tt.data {
+			t.Errorf("%d. %s => %q, wanted: %q", i, tt.file, data, tt.data)
+		}
+	}
+
+	if info, _ := os.Stat(path.Join(root, users.SHADOW)); info.Mode().Perm() != 0640 {
+		t.Errorf("%s mode => %#o, wanted: 0640", users.SHADOW, info.Mode().Perm())
+	}
+	if data, _ := ioutil.ReadFile(path.Join(root, users.SHADOW)); !strings.Contains(string(data), "\ncache:!:") {
+		t.Errorf("%s => %q, wanted a locked entry for cache", users.SHADOW, data)
+	}
+}